@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+)
+
+// maxBatchSize is the most sub-requests one Blob Batch request may carry
+const maxBatchSize = 256
+
+// errMissingSubResponse is the error of a change the batch response did not answer.
+// It is transient, so the change is retried on its own.
+var errMissingSubResponse = errors.New("batch response has no sub-response for the change")
+
+// tierChange is a SetTier call deferred to a Blob Batch request
+type tierChange struct {
+	Location blobLocation
+	Tier     blob.AccessTier
+	Priority blob.RehydratePriority
+}
+
+// batchKey groups changes that may share a batch. Batches are scoped to a container,
+// the only scope Azure Storage authorises with a token credential.
+func (c tierChange) batchKey() string {
+	return c.Location.endpoint() + "/" + c.Location.Container
+}
+
+// tierBatch is a group of deferred changes submitted together, with the indexes of the
+// outcomes they belong to
+type tierBatch struct {
+	Changes  []tierChange
+	Outcomes []int
+}
+
+// groupTierChanges splits the changes deferred by outcomes into batches of at most
+// size changes sharing a container, in outcome order. Each blob is changed once: a
+// later outcome deferring a change to a blob already batched, from a repeated row or
+// a prefix match, is left out and its index returned among the duplicates.
+func groupTierChanges(outcomes []rowOutcome, size int) ([]tierBatch, []int) {
+	var batches []tierBatch
+	var duplicates []int
+	open := make(map[string]int)
+	seen := make(map[string]bool)
+	for i, outcome := range outcomes {
+		change := outcome.Result.SetTier
+		if change == nil {
+			continue
+		}
+		target := change.Location.URL()
+		if seen[target] {
+			duplicates = append(duplicates, i)
+			continue
+		}
+		seen[target] = true
+
+		key := change.batchKey()
+		b, ok := open[key]
+		if !ok || len(batches[b].Changes) == size {
+			batches = append(batches, tierBatch{})
+			b = len(batches) - 1
+			open[key] = b
+		}
+		batches[b].Changes = append(batches[b].Changes, *change)
+		batches[b].Outcomes = append(batches[b].Outcomes, i)
+	}
+	return batches, duplicates
+}
+
+// duplicateResult is the result of a row whose blob's tier change was left to an
+// earlier row; a second change would fail while the first is in progress
+func duplicateResult(result tierResult) tierResult {
+	return tierResult{
+		Code:     resultSkipped,
+		Status:   "Skipped: Same blob as an earlier row, which reports its tier change",
+		FromTier: result.FromTier,
+		Version:  result.Version,
+	}
+}
+
+// runTierBatches submits the SetTier calls deferred by outcomes as Blob Batch requests
+// and maps each sub-response back to its outcome. Rows whose sub-request failed with a
+// transient error, or whose whole batch failed, are retried one by one through
+// processBlobTier.
+func runTierBatches(ctx context.Context, tasks []rowTask, outcomes []rowOutcome, size, workers, perAccount int, retry retryPolicy) {
+	batches, duplicates := groupTierChanges(outcomes, size)
+	for _, i := range duplicates {
+		outcomes[i].Result = duplicateResult(outcomes[i].Result)
+	}
+	if len(batches) == 0 {
+		return
+	}
+	log.Printf("Submitting %d SetTier batches", len(batches))
+
+	limiter := newAccountLimiter(perAccount)
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, len(batches)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range indexes {
+				batch := batches[b]
+				account := batch.Changes[0].Location.Account
+
+				release := limiter.acquire(account)
+				errs, err := storage.SetTierBatch(ctx, batch.Changes)
+				release()
+				if err != nil {
+					log.Printf("SetTier batch of %d blobs in %s failed, falling back to single requests: %v",
+						len(batch.Changes), batch.Changes[0].batchKey(), err)
+				}
+
+				for j, i := range batch.Outcomes {
+					switch {
+					case err == nil && errs[j] == nil:
+						outcomes[i].Result.SetTier = nil
+					case err == nil && !isTransient(errs[j]):
+						result := outcomes[i].Result
+						outcomes[i].Result = tierResult{Status: "Error: Failed to set tier", FromTier: result.FromTier, Version: result.Version}
+						outcomes[i].Err = errs[j]
+					default:
+						outcomes[i] = retrySingle(ctx, tasks[i], outcomes[i], limiter, retry)
+					}
+				}
+			}
+		}()
+	}
+
+	for b := range batches {
+		indexes <- b
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// retrySingle processes a task whose batched SetTier did not go through with its own
+// requests, adding the attempts to those already made
+func retrySingle(ctx context.Context, task rowTask, outcome rowOutcome, limiter *accountLimiter, retry retryPolicy) rowOutcome {
+	release := limiter.acquire(task.Source.Account)
+	defer release()
+
+	result, attempts, err := retry.do(ctx, task.Source.String(), func() (tierResult, error) {
+		return processBlobTier(ctx, task.Source, task.Req)
+	})
+	return rowOutcome{Result: result, Err: err, Attempts: outcome.Attempts + attempts}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+)
+
+// unansweredBatchStorage is a memoryStorage whose tier batches leave their last change
+// unapplied and unanswered
+type unansweredBatchStorage struct {
+	*memoryStorage
+}
+
+// SetTierBatch implements storageBackend
+func (s unansweredBatchStorage) SetTierBatch(ctx context.Context, changes []tierChange) ([]error, error) {
+	errs, err := s.memoryStorage.SetTierBatch(ctx, changes[:len(changes)-1])
+	return append(errs, errMissingSubResponse), err
+}
+
+func TestRunRowTasksRetriesUnansweredBatchChanges(t *testing.T) {
+	s := useMemoryStorage(t, time.Hour)
+	storage = unansweredBatchStorage{s.memoryStorage}
+
+	var tasks []rowTask
+	for _, path := range []string{"a.txt", "b.txt", "c.txt"} {
+		loc := blobLocation{Account: "acct", Container: "c", Path: path}
+		s.put(loc, []byte("data"), "text/plain", blob.AccessTierHot)
+		tasks = append(tasks, rowTask{Source: loc, Req: tierRequest{TargetTier: blob.AccessTierCool}})
+	}
+
+	outcomes := runRowTasks(context.Background(), tasks, 2, 2, 3, retryPolicy{MaxAttempts: 2})
+	for i, outcome := range outcomes {
+		if outcome.Err != nil || outcome.Result.Code != resultChanged {
+			t.Errorf("%s: outcome %+v, want %s", tasks[i].Source, outcome, resultChanged)
+		}
+		props, err := s.GetProperties(context.Background(), tasks[i].Source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if props.AccessTier != blob.AccessTierCool {
+			t.Errorf("%s is %s, want Cool", tasks[i].Source, props.AccessTier)
+		}
+	}
+	if got := outcomes[2].Attempts; got != 2 {
+		t.Errorf("unanswered change took %d attempts, want 2 (the batch and a single retry)", got)
+	}
+}
+
+func TestRunRowTasksChangesDuplicateBlobsOnce(t *testing.T) {
+	s := useMemoryStorage(t, time.Hour)
+	loc := blobLocation{Account: "acct", Container: "c", Path: "a.txt"}
+	s.put(loc, []byte("data"), "text/plain", blob.AccessTierArchive)
+
+	task := rowTask{Source: loc, Req: tierRequest{TargetTier: blob.AccessTierCool}}
+	outcomes := runRowTasks(context.Background(), []rowTask{task, task}, 2, 2, 2, retryPolicy{MaxAttempts: 1})
+	if got := outcomes[0]; got.Err != nil || !got.Result.Pending {
+		t.Errorf("first row: outcome %+v, want a pending rehydration", got)
+	}
+	if got := outcomes[1]; got.Err != nil || got.Result.Code != resultSkipped || got.Result.SetTier != nil {
+		t.Errorf("repeated row: outcome %+v, want %s without a tier change", got, resultSkipped)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// defaultBlobEndpointSuffixes are the blob endpoint suffixes of the Azure public,
+// China and US Government clouds
+var defaultBlobEndpointSuffixes = []string{"blob.core.windows.net", "blob.core.chinacloudapi.cn", "blob.core.usgovcloudapi.net"}
+
+// endpoints describes the blob hosts autotier accepts, set up in main
+var endpoints *blobEndpoints
+
+// blobEndpoints describes the hosts parseBlobURL recognises
+type blobEndpoints struct {
+	// Suffixes match account-style hosts, <account>.<suffix> or <account>.privatelink.<suffix>.
+	// The first suffix is used to reach accounts known only by name.
+	Suffixes []string
+	// CustomDomains maps custom domain hosts to their storage account
+	CustomDomains map[string]string
+	// PathStyleHosts are hosts whose first path segment is the account, as used by
+	// Azurite. Loopback addresses and localhost are always path-style.
+	PathStyleHosts []string
+}
+
+// loadBlobEndpoints reads the accepted hosts from the environment:
+// BLOB_ENDPOINT_SUFFIXES replaces the default suffix list, BLOB_CUSTOM_DOMAINS lists
+// host=account pairs and BLOB_PATH_STYLE_HOSTS lists host[:port] entries.
+func loadBlobEndpoints() (*blobEndpoints, error) {
+	e := &blobEndpoints{Suffixes: defaultBlobEndpointSuffixes, CustomDomains: make(map[string]string)}
+
+	if suffixes := splitList(os.Getenv("BLOB_ENDPOINT_SUFFIXES")); len(suffixes) > 0 {
+		e.Suffixes = nil
+		for _, suffix := range suffixes {
+			e.Suffixes = append(e.Suffixes, strings.ToLower(strings.Trim(suffix, ".")))
+		}
+	}
+
+	for _, entry := range splitList(os.Getenv("BLOB_CUSTOM_DOMAINS")) {
+		host, account, ok := strings.Cut(entry, "=")
+		host, account = strings.ToLower(strings.TrimSpace(host)), strings.TrimSpace(account)
+		if !ok || host == "" || account == "" {
+			return nil, fmt.Errorf("invalid BLOB_CUSTOM_DOMAINS entry %q: expected host=account", entry)
+		}
+		e.CustomDomains[host] = account
+	}
+
+	for _, host := range splitList(os.Getenv("BLOB_PATH_STYLE_HOSTS")) {
+		e.PathStyleHosts = append(e.PathStyleHosts, strings.ToLower(host))
+	}
+	return e, nil
+}
+
+// accountEndpoint returns the blob service endpoint of an account known only by name
+func (e *blobEndpoints) accountEndpoint(account string) string {
+	return fmt.Sprintf("https://%s.%s", account, e.Suffixes[0])
+}
+
+// account returns the storage account of an account-style or custom domain host, and
+// the blob endpoint host to reach it. Data Lake (dfs) hosts resolve to the account's
+// blob host.
+func (e *blobEndpoints) account(host string) (string, string, bool) {
+	if account, ok := e.CustomDomains[host]; ok {
+		return account, host, true
+	}
+
+	hostname, port := host, ""
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		hostname, port = h, ":"+p
+	}
+	for _, suffix := range e.Suffixes {
+		for _, hostSuffix := range []string{suffix, dfsSuffix(suffix)} {
+			prefix, found := strings.CutSuffix(hostname, "."+hostSuffix)
+			if !found || hostSuffix == "" {
+				continue
+			}
+			account := strings.TrimSuffix(prefix, ".privatelink")
+			if account != "" && !strings.Contains(account, ".") {
+				return account, prefix + "." + suffix + port, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// dfsSuffix returns the Data Lake endpoint suffix paired with a blob endpoint suffix,
+// or "" when the suffix does not start with "blob."
+func dfsSuffix(blobSuffix string) string {
+	if rest, ok := strings.CutPrefix(blobSuffix, "blob."); ok {
+		return "dfs." + rest
+	}
+	return ""
+}
+
+// pathStyle reports whether the account of host is the first path segment
+func (e *blobEndpoints) pathStyle(host string) bool {
+	if slices.Contains(e.PathStyleHosts, host) {
+		return true
+	}
+
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	if slices.Contains(e.PathStyleHosts, hostname) || hostname == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(hostname)
+	return ip != nil && ip.IsLoopback()
+}
+
+// parseBlobURL parses a blob service URL into its account, container and blob path.
+// Data Lake locations are resolved to the equivalent blob: dfs endpoint URLs and
+// abfs[s]://container@account.dfs.core.windows.net/path or
+// wasb[s]://container@account.blob.core.windows.net/path URIs. A versionid or snapshot
+// query selects a previous version or a snapshot of the blob; the rest of the query
+// string, such as a SAS, is ignored. The container and path may be empty when the URL
+// names an account or container only.
+//
+// Manifests often hold blob names pasted unescaped, so the URL is split by hand rather
+// than with url.Parse: a "#" is part of the blob name, never a fragment, and the
+// container and path are percent-decoded only when they are validly encoded, so a
+// name such as "50%off.txt" is taken literally.
+func parseBlobURL(rawURL string) (blobLocation, error) {
+	scheme, rest, ok := strings.Cut(strings.TrimSpace(rawURL), "://")
+	if !ok {
+		return blobLocation{}, fmt.Errorf("invalid blob URL %q: expected an http(s), abfs(s) or wasb(s) URL", rawURL)
+	}
+	scheme = strings.ToLower(scheme)
+
+	rest, query, _ := strings.Cut(rest, "?")
+	authority, rest, _ := strings.Cut(rest, "/")
+	var user string
+	if i := strings.LastIndex(authority, "@"); i >= 0 {
+		user, authority = authority[:i], authority[i+1:]
+	}
+	host := strings.ToLower(authority)
+	if host == "" {
+		return blobLocation{}, fmt.Errorf("invalid blob URL %q: missing host", rawURL)
+	}
+
+	// Hadoop filesystem URIs name the container as the user part of the authority
+	endpointScheme := scheme
+	switch scheme {
+	case "https", "http":
+	case "abfss", "wasbs", "abfs", "wasb":
+		endpointScheme = "https"
+		if scheme == "abfs" || scheme == "wasb" {
+			endpointScheme = "http"
+		}
+		if user == "" {
+			return blobLocation{}, fmt.Errorf("invalid blob URL %q: expected %s://container@account host", rawURL, scheme)
+		}
+		if _, _, ok := endpoints.account(host); !ok {
+			return blobLocation{}, fmt.Errorf("invalid blob URL %q: %s is not a known storage endpoint", rawURL, authority)
+		}
+		rest = user + "/" + rest
+	default:
+		return blobLocation{}, fmt.Errorf("invalid blob URL %q: expected an http(s), abfs(s) or wasb(s) URL", rawURL)
+	}
+	rest = unescapeBlobPath(rest)
+
+	var loc blobLocation
+	if account, blobHost, ok := endpoints.account(host); ok {
+		loc.Account = account
+		loc.Endpoint = fmt.Sprintf("%s://%s", endpointScheme, blobHost)
+	} else if endpoints.pathStyle(host) {
+		account, path, _ := strings.Cut(rest, "/")
+		if account == "" {
+			return blobLocation{}, fmt.Errorf("invalid blob URL %q: missing account in path", rawURL)
+		}
+		loc.Account = account
+		loc.Endpoint = fmt.Sprintf("%s://%s/%s", scheme, host, url.PathEscape(account))
+		rest = path
+	} else {
+		return blobLocation{}, fmt.Errorf("invalid blob URL %q: %s is not a known blob endpoint", rawURL, authority)
+	}
+
+	loc.Container, loc.Path, _ = strings.Cut(rest, "/")
+
+	// A malformed SAS parameter does not stop the version or snapshot being read
+	values, _ := url.ParseQuery(query)
+	for key, v := range values {
+		switch strings.ToLower(key) {
+		case "versionid":
+			loc.VersionID = v[0]
+		case "snapshot":
+			loc.Snapshot = v[0]
+		}
+	}
+	if loc.VersionID != "" && loc.Snapshot != "" {
+		return blobLocation{}, fmt.Errorf("invalid blob URL %q: expected a versionid or a snapshot, not both", rawURL)
+	}
+	return loc, nil
+}
+
+// unescapeBlobPath percent-decodes a blob path taken from a URL, or returns it as-is
+// when it is not validly encoded and so was written unescaped
+func unescapeBlobPath(escaped string) string {
+	if decoded, err := url.PathUnescape(escaped); err == nil {
+		return decoded
+	}
+	return escaped
+}
+
+// parseBlobRef parses the URL of a single blob, requiring a container and blob path
+func parseBlobRef(rawURL string) (blobLocation, error) {
+	loc, err := parseBlobURL(rawURL)
+	if err != nil {
+		return blobLocation{}, err
+	}
+	if loc.Container == "" || loc.Path == "" || strings.HasSuffix(loc.Path, "/") {
+		return blobLocation{}, fmt.Errorf("invalid blob URL %q: expected a container and blob path", rawURL)
+	}
+	return loc, nil
+}
+
+// escapeBlobPath percent-encodes each segment of a decoded blob path
+func escapeBlobPath(blobPath string) string {
+	segments := strings.Split(blobPath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"testing"
+)
+
+// useEndpoints sets the endpoints global for a test, restoring it afterwards
+func useEndpoints(t *testing.T, e *blobEndpoints) {
+	t.Helper()
+	previous := endpoints
+	endpoints = e
+	t.Cleanup(func() { endpoints = previous })
+}
+
+// defaultEndpoints returns the endpoints of an unconfigured deployment
+func defaultEndpoints(t *testing.T) *blobEndpoints {
+	t.Helper()
+	e, err := loadBlobEndpoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestParseBlobURL(t *testing.T) {
+	e := defaultEndpoints(t)
+	e.CustomDomains["files.example.com"] = "acct"
+	useEndpoints(t, e)
+
+	tests := []struct {
+		name string
+		url  string
+		want blobLocation
+	}{
+		{
+			name: "blob endpoint",
+			url:  "https://acct.blob.core.windows.net/c/dir/a.txt",
+			want: blobLocation{Account: "acct", Container: "c", Path: "dir/a.txt", Endpoint: "https://acct.blob.core.windows.net"},
+		},
+		{
+			name: "escaped path and SAS",
+			url:  "https://acct.blob.core.windows.net/c/my%20file.txt?sv=2024&sig=abc",
+			want: blobLocation{Account: "acct", Container: "c", Path: "my file.txt", Endpoint: "https://acct.blob.core.windows.net"},
+		},
+		{
+			name: "unescaped hash",
+			url:  "https://acct.blob.core.windows.net/c/dir/a#1.txt",
+			want: blobLocation{Account: "acct", Container: "c", Path: "dir/a#1.txt", Endpoint: "https://acct.blob.core.windows.net"},
+		},
+		{
+			name: "escaped hash",
+			url:  "https://acct.blob.core.windows.net/c/dir/a%231.txt",
+			want: blobLocation{Account: "acct", Container: "c", Path: "dir/a#1.txt", Endpoint: "https://acct.blob.core.windows.net"},
+		},
+		{
+			name: "unescaped percent",
+			url:  "https://acct.blob.core.windows.net/c/50%off.txt",
+			want: blobLocation{Account: "acct", Container: "c", Path: "50%off.txt", Endpoint: "https://acct.blob.core.windows.net"},
+		},
+		{
+			name: "version",
+			url:  "https://acct.blob.core.windows.net/c/a.txt?versionid=2024-01-01T00:00:00.0000000Z",
+			want: blobLocation{Account: "acct", Container: "c", Path: "a.txt", Endpoint: "https://acct.blob.core.windows.net", VersionID: "2024-01-01T00:00:00.0000000Z"},
+		},
+		{
+			name: "snapshot",
+			url:  "https://acct.blob.core.windows.net/c/a.txt?snapshot=2024-01-01T00:00:00.0000000Z",
+			want: blobLocation{Account: "acct", Container: "c", Path: "a.txt", Endpoint: "https://acct.blob.core.windows.net", Snapshot: "2024-01-01T00:00:00.0000000Z"},
+		},
+		{
+			name: "private link",
+			url:  "https://acct.privatelink.blob.core.windows.net/c/a.txt",
+			want: blobLocation{Account: "acct", Container: "c", Path: "a.txt", Endpoint: "https://acct.privatelink.blob.core.windows.net"},
+		},
+		{
+			name: "sovereign cloud",
+			url:  "https://acct.blob.core.chinacloudapi.cn/c/a.txt",
+			want: blobLocation{Account: "acct", Container: "c", Path: "a.txt", Endpoint: "https://acct.blob.core.chinacloudapi.cn"},
+		},
+		{
+			name: "data lake endpoint",
+			url:  "https://acct.dfs.core.windows.net/fs/dir/a.parquet",
+			want: blobLocation{Account: "acct", Container: "fs", Path: "dir/a.parquet", Endpoint: "https://acct.blob.core.windows.net"},
+		},
+		{
+			name: "abfss",
+			url:  "abfss://fs@acct.dfs.core.windows.net/dir/a.parquet",
+			want: blobLocation{Account: "acct", Container: "fs", Path: "dir/a.parquet", Endpoint: "https://acct.blob.core.windows.net"},
+		},
+		{
+			name: "wasb",
+			url:  "wasb://c@acct.blob.core.windows.net/a.txt",
+			want: blobLocation{Account: "acct", Container: "c", Path: "a.txt", Endpoint: "http://acct.blob.core.windows.net"},
+		},
+		{
+			name: "custom domain",
+			url:  "https://files.example.com/c/a.txt",
+			want: blobLocation{Account: "acct", Container: "c", Path: "a.txt", Endpoint: "https://files.example.com"},
+		},
+		{
+			name: "azurite",
+			url:  "http://127.0.0.1:10000/devstoreaccount1/c/a.txt",
+			want: blobLocation{Account: "devstoreaccount1", Container: "c", Path: "a.txt", Endpoint: "http://127.0.0.1:10000/devstoreaccount1"},
+		},
+		{
+			name: "container",
+			url:  "https://acct.blob.core.windows.net/c",
+			want: blobLocation{Account: "acct", Container: "c", Endpoint: "https://acct.blob.core.windows.net"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBlobURL(tt.url)
+			if err != nil {
+				t.Fatalf("parseBlobURL(%q): %v", tt.url, err)
+			}
+			if got != tt.want {
+				t.Errorf("parseBlobURL(%q) = %+v, want %+v", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBlobURLErrors(t *testing.T) {
+	useEndpoints(t, defaultEndpoints(t))
+
+	for _, url := range []string{
+		"",
+		"not a url",
+		"ftp://acct.blob.core.windows.net/c/a.txt",
+		"https://example.com/c/a.txt",
+		"https://acct.blob.core.windows.net/c/a.txt?versionid=v&snapshot=s",
+		"abfss://acct.dfs.core.windows.net/dir/a.parquet",
+		"abfss://fs@example.com/dir/a.parquet",
+		"http://localhost:10000/",
+	} {
+		if loc, err := parseBlobURL(url); err == nil {
+			t.Errorf("parseBlobURL(%q) = %+v, want an error", url, loc)
+		}
+	}
+}
+
+func TestParseBlobRef(t *testing.T) {
+	useEndpoints(t, defaultEndpoints(t))
+
+	if _, err := parseBlobRef("https://acct.blob.core.windows.net/c/a.txt"); err != nil {
+		t.Errorf("parseBlobRef of a blob URL: %v", err)
+	}
+	for _, url := range []string{
+		"https://acct.blob.core.windows.net/",
+		"https://acct.blob.core.windows.net/c",
+		"https://acct.blob.core.windows.net/c/dir/",
+	} {
+		if _, err := parseBlobRef(url); err == nil {
+			t.Errorf("parseBlobRef(%q) succeeded, want an error", url)
+		}
+	}
+}
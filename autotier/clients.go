@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+)
+
+// azureStorage is the storageBackend for Azure Storage. It holds one credential for
+// the process and one service client per blob endpoint, so token requests and
+// connection setup scale with accounts, not rows.
+type azureStorage struct {
+	cred azcore.TokenCredential
+
+	mu       sync.Mutex
+	services map[string]*service.Client
+}
+
+// serviceClientOptions turns off the SDK's own retries. Row operations are retried by
+// retryPolicy, which counts each attempt against the row and the job's budget; SDK
+// retries underneath would multiply the attempts and outlast the budget.
+var serviceClientOptions = &service.ClientOptions{
+	ClientOptions: azcore.ClientOptions{Retry: policy.RetryOptions{MaxRetries: -1}},
+}
+
+// withSDKRetries restores the SDK's default retries for a call that retryPolicy does
+// not cover, such as reading the manifest, uploading the output or listing a prefix
+func withSDKRetries(ctx context.Context) context.Context {
+	return policy.WithRetryOptions(ctx, policy.RetryOptions{})
+}
+
+// newAzureStorage creates the client cache around the managed identity credential
+func newAzureStorage() (*azureStorage, error) {
+	cred, err := azidentity.NewManagedIdentityCredential(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MI credential: %w", err)
+	}
+	return &azureStorage{cred: cred, services: make(map[string]*service.Client)}, nil
+}
+
+// service returns the cached service client for a blob service endpoint, creating it on first use
+func (c *azureStorage) service(endpoint string) (*service.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if client, ok := c.services[endpoint]; ok {
+		return client, nil
+	}
+
+	client, err := service.NewClient(endpoint+"/", c.cred, serviceClientOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create service client for %s: %w", endpoint, err)
+	}
+	c.services[endpoint] = client
+	return client, nil
+}
+
+// container returns a client for the container of loc
+func (c *azureStorage) container(loc blobLocation) (*container.Client, error) {
+	serviceClient, err := c.service(loc.endpoint())
+	if err != nil {
+		return nil, err
+	}
+	return serviceClient.NewContainerClient(loc.Container), nil
+}
+
+// blob returns a client for a blob, or for the version or snapshot loc names. The SDK
+// encodes the decoded container and path itself.
+func (c *azureStorage) blob(loc blobLocation) (*blob.Client, error) {
+	containerClient, err := c.container(loc)
+	if err != nil {
+		return nil, err
+	}
+
+	blobClient := containerClient.NewBlobClient(loc.Path)
+	switch {
+	case loc.VersionID != "":
+		return blobClient.WithVersionID(loc.VersionID)
+	case loc.Snapshot != "":
+		return blobClient.WithSnapshot(loc.Snapshot)
+	}
+	return blobClient, nil
+}
+
+// GetProperties implements storageBackend
+func (c *azureStorage) GetProperties(ctx context.Context, loc blobLocation) (blobProperties, error) {
+	blobClient, err := c.blob(loc)
+	if err != nil {
+		return blobProperties{}, err
+	}
+
+	resp, err := blobClient.GetProperties(ctx, nil)
+	if err != nil {
+		return blobProperties{}, err
+	}
+
+	props := blobProperties{
+		ArchiveStatus:        deref(resp.ArchiveStatus),
+		RehydratePriority:    blob.RehydratePriority(deref(resp.RehydratePriority)),
+		AccessTier:           blob.AccessTier(deref(resp.AccessTier)),
+		AccessTierChangeTime: resp.AccessTierChangeTime,
+		VersionID:            deref(resp.VersionID),
+		ContentLength:        deref(resp.ContentLength),
+		LastModified:         deref(resp.LastModified),
+		CopyID:               deref(resp.CopyID),
+		CopyStatus:           deref(resp.CopyStatus),
+		CopyProgress:         deref(resp.CopyProgress),
+		CopyCompletionTime:   resp.CopyCompletionTime,
+	}
+	if resp.ETag != nil {
+		props.ETag = string(*resp.ETag)
+	}
+	return props, nil
+}
+
+// SetTier implements storageBackend
+func (c *azureStorage) SetTier(ctx context.Context, loc blobLocation, tier blob.AccessTier, priority blob.RehydratePriority) error {
+	blobClient, err := c.blob(loc)
+	if err != nil {
+		return err
+	}
+
+	var options *blob.SetTierOptions
+	if priority != "" {
+		options = &blob.SetTierOptions{RehydratePriority: &priority}
+	}
+	_, err = blobClient.SetTier(ctx, tier, options)
+	return err
+}
+
+// SetTierBatch implements storageBackend. Sub-responses are matched to changes by
+// their Content-ID, the index of the sub-request; a change without one is reported
+// as errMissingSubResponse rather than assumed to have succeeded.
+func (c *azureStorage) SetTierBatch(ctx context.Context, changes []tierChange) ([]error, error) {
+	containerClient, err := c.container(changes[0].Location)
+	if err != nil {
+		return nil, err
+	}
+
+	bb, err := containerClient.NewBatchBuilder()
+	if err != nil {
+		return nil, err
+	}
+	for _, change := range changes {
+		options := &container.BatchSetTierOptions{}
+		if change.Priority != "" {
+			options.RehydratePriority = &change.Priority
+		}
+		if change.Location.VersionID != "" {
+			options.VersionID = &change.Location.VersionID
+		}
+		if change.Location.Snapshot != "" {
+			options.Snapshot = &change.Location.Snapshot
+		}
+		if err := bb.SetTier(change.Location.Path, change.Tier, options); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := containerClient.SubmitBatch(ctx, bb, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, len(changes))
+	for i := range errs {
+		errs[i] = errMissingSubResponse
+	}
+	for i, item := range resp.Responses {
+		index := i
+		if item.ContentID != nil {
+			index = *item.ContentID
+		}
+		if index < 0 || index >= len(errs) {
+			return nil, fmt.Errorf("batch response has unexpected Content-ID %d", index)
+		}
+		errs[index] = item.Error
+	}
+	return errs, nil
+}
+
+// StartCopy implements storageBackend. Copies into another account read the source
+// through a user delegation SAS.
+func (c *azureStorage) StartCopy(ctx context.Context, source, dest blobLocation, tier blob.AccessTier, priority blob.RehydratePriority) (copyInfo, error) {
+	sourceClient, err := c.blob(source)
+	if err != nil {
+		return copyInfo{}, err
+	}
+
+	copySource := sourceClient.URL()
+	if dest.Account != source.Account {
+		sasURL, err := c.sourceSASURL(ctx, source, sourceClient)
+		if err != nil {
+			return copyInfo{}, err
+		}
+		copySource = sasURL
+	}
+
+	destClient, err := c.blob(dest)
+	if err != nil {
+		return copyInfo{}, err
+	}
+
+	options := &blob.StartCopyFromURLOptions{Tier: &tier}
+	if priority != "" {
+		options.RehydratePriority = &priority
+	}
+	resp, err := destClient.StartCopyFromURL(ctx, copySource, options)
+	if err != nil {
+		return copyInfo{}, err
+	}
+
+	info := copyInfo{ID: deref(resp.CopyID), Status: blob.CopyStatusTypePending}
+	if resp.CopyStatus != nil {
+		info.Status = *resp.CopyStatus
+	}
+	return info, nil
+}
+
+// sourceSASURL returns the source blob URL with a read-only user delegation SAS, so a
+// copy into another storage account can read it with the managed identity's rights
+func (c *azureStorage) sourceSASURL(ctx context.Context, source blobLocation, sourceClient *blob.Client) (string, error) {
+	serviceClient, err := c.service(source.endpoint())
+	if err != nil {
+		return "", err
+	}
+
+	start := time.Now().UTC().Add(-5 * time.Minute)
+	expiry := start.Add(copySourceSASLifetime)
+	udc, err := serviceClient.GetUserDelegationCredential(ctx, service.KeyInfo{
+		Start:  to.Ptr(start.Format(sas.TimeFormat)),
+		Expiry: to.Ptr(expiry.Format(sas.TimeFormat)),
+	}, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get user delegation key: %w", err)
+	}
+
+	values := sas.BlobSignatureValues{
+		Protocol:      sas.ProtocolHTTPS,
+		StartTime:     start,
+		ExpiryTime:    expiry,
+		Permissions:   (&sas.BlobPermissions{Read: true}).String(),
+		ContainerName: source.Container,
+		BlobName:      source.Path,
+		BlobVersion:   source.VersionID,
+	}
+	if source.Snapshot != "" {
+		if values.SnapshotTime, err = time.Parse(time.RFC3339Nano, source.Snapshot); err != nil {
+			return "", fmt.Errorf("invalid snapshot %q: %w", source.Snapshot, err)
+		}
+	}
+	qp, err := values.SignWithUserDelegation(udc)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign copy source SAS: %w", err)
+	}
+
+	// A version or snapshot URL already carries a query string
+	separator := "?"
+	if strings.Contains(sourceClient.URL(), "?") {
+		separator = "&"
+	}
+	return sourceClient.URL() + separator + qp.Encode(), nil
+}
+
+// Download implements storageBackend
+func (c *azureStorage) Download(ctx context.Context, loc blobLocation) (*downloadedBlob, error) {
+	ctx = withSDKRetries(ctx)
+	blobClient, err := c.blob(loc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create blob client: %w", err)
+	}
+
+	resp, err := blobClient.DownloadStream(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download blob: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob: %w", err)
+	}
+
+	downloaded := &downloadedBlob{Data: data, Metadata: resp.Metadata}
+	if resp.ETag != nil {
+		downloaded.ETag = string(*resp.ETag)
+	}
+	return downloaded, nil
+}
+
+// Upload implements storageBackend
+func (c *azureStorage) Upload(ctx context.Context, loc blobLocation, data []byte, contentType string) error {
+	ctx = withSDKRetries(ctx)
+	containerClient, err := c.container(loc)
+	if err != nil {
+		return err
+	}
+
+	// Ensure the container exists
+	if _, err := containerClient.GetProperties(ctx, nil); err != nil {
+		if !bloberror.HasCode(err, bloberror.ContainerNotFound) {
+			return fmt.Errorf("failed to check container %s: %w", loc.Container, err)
+		}
+		if _, err := containerClient.Create(ctx, nil); err != nil && !bloberror.HasCode(err, bloberror.ContainerAlreadyExists) {
+			return fmt.Errorf("failed to create container %s: %w", loc.Container, err)
+		}
+		log.Printf("Created container: %s in storage account: %s", loc.Container, loc.Account)
+	}
+
+	reader := ReadSeekCloser{bytes.NewReader(data)}
+	_, err = containerClient.NewBlockBlobClient(loc.Path).Upload(ctx, reader, &blockblob.UploadOptions{
+		HTTPHeaders: &blob.HTTPHeaders{
+			BlobContentType: &contentType,
+		},
+	})
+	return err
+}
+
+// List implements storageBackend
+func (c *azureStorage) List(ctx context.Context, loc blobLocation, limit int) ([]blobItem, error) {
+	ctx = withSDKRetries(ctx)
+	containerClient, err := c.container(loc)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []blobItem
+	pager := containerClient.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{Prefix: &loc.Path})
+	for pager.More() && (limit <= 0 || len(items) < limit) {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Segment.BlobItems {
+			if item.Name == nil {
+				continue
+			}
+			itemLoc := loc.base()
+			itemLoc.Path = *item.Name
+
+			var props blobProperties
+			if p := item.Properties; p != nil {
+				props = blobProperties{
+					AccessTier:           blob.AccessTier(deref(p.AccessTier)),
+					ArchiveStatus:        string(deref(p.ArchiveStatus)),
+					RehydratePriority:    blob.RehydratePriority(deref(p.RehydratePriority)),
+					AccessTierChangeTime: p.AccessTierChangeTime,
+					ContentLength:        deref(p.ContentLength),
+					LastModified:         deref(p.LastModified),
+					CopyID:               deref(p.CopyID),
+					CopyStatus:           deref(p.CopyStatus),
+					CopyProgress:         deref(p.CopyProgress),
+					CopyCompletionTime:   p.CopyCompletionTime,
+				}
+				if p.ETag != nil {
+					props.ETag = string(*p.ETag)
+				}
+			}
+			items = append(items, blobItem{Location: itemLoc, Properties: props})
+		}
+	}
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	return items, nil
+}
+
+// deref returns the value p points to, or the zero value when p is nil
+func deref[T any](p *T) T {
+	var zero T
+	if p == nil {
+		return zero
+	}
+	return *p
+}
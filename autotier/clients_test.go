@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+)
+
+// staticCredential is a token credential that is never asked for a token in tests
+type staticCredential struct{}
+
+// GetToken implements azcore.TokenCredential
+func (staticCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token"}, nil
+}
+
+func TestAzureStorageCachesServiceClients(t *testing.T) {
+	c := &azureStorage{cred: staticCredential{}, services: make(map[string]*service.Client)}
+
+	first, err := c.service("https://acct.blob.core.windows.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.service("https://acct.blob.core.windows.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Error("service returned a new client for a cached endpoint")
+	}
+	other, err := c.service("https://other.blob.core.windows.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == first {
+		t.Error("service shared a client between endpoints")
+	}
+}
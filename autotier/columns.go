@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// uploaderMetadataKey is the blob metadata key the upload service records the signed-in
+// uploader under, path escaped, used to pick their column mapping overrides. The upload
+// service takes the uploader from App Service authentication, so the overrides are
+// only as trustworthy as that authentication.
+const uploaderMetadataKey = "uploader"
+
+// columnMappings is the column mapping configuration, set up in main
+var columnMappings *columnMappingConfig
+
+// columnMapping tells planSheet where a manifest's data is instead of leaving it to
+// the header heuristics. Each field is optional; an empty one keeps the heuristic.
+// Columns are named by header text (case insensitive) or by letter with a leading "$",
+// e.g. "$C".
+type columnMapping struct {
+	// Sheets are the worksheets to process, in place of PROCESS_SHEETS
+	Sheets []string `json:"sheets,omitempty"`
+	// HeaderRow is the one-based row holding the headers, in place of the Excel table
+	// or detected header row; the rows above it are ignored. It does not apply to JSON
+	// manifests, whose keys are their headers.
+	HeaderRow   int    `json:"headerRow,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Tier        string `json:"tier,omitempty"`
+	Priority    string `json:"priority,omitempty"`
+	Destination string `json:"destination,omitempty"`
+}
+
+// columnMappingConfig is the deployment's column mapping, with overrides for the
+// manifests of particular uploaders
+type columnMappingConfig struct {
+	columnMapping
+	// Uploaders maps an uploader, as recorded in the manifest's uploader metadata, to
+	// the fields that differ for their manifests. Uploaders are matched case
+	// insensitively. Without App Service authentication in front of the upload service
+	// a client can name any uploader, so overrides must not grant anything a manifest
+	// could not ask for itself.
+	Uploaders map[string]columnMapping `json:"uploaders,omitempty"`
+}
+
+// loadColumnMapping reads the column mapping from COLUMN_MAPPING, which holds either
+// the JSON configuration itself or the path of a file containing it. Without it every
+// manifest is left to the header heuristics.
+func loadColumnMapping() (*columnMappingConfig, error) {
+	c := &columnMappingConfig{}
+	v := strings.TrimSpace(os.Getenv("COLUMN_MAPPING"))
+	if v == "" {
+		return c, nil
+	}
+
+	data := []byte(v)
+	if !strings.HasPrefix(v, "{") {
+		var err error
+		if data, err = os.ReadFile(v); err != nil {
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to read COLUMN_MAPPING: %w", err)
+	}
+
+	mappings := []columnMapping{c.columnMapping}
+	for _, m := range c.Uploaders {
+		mappings = append(mappings, m)
+	}
+	for _, m := range mappings {
+		if m.HeaderRow < 0 {
+			return nil, fmt.Errorf("invalid COLUMN_MAPPING header row %d", m.HeaderRow)
+		}
+		for _, ref := range []string{m.URL, m.Tier, m.Priority, m.Destination} {
+			if _, err := columnLetterIndex(ref); err != nil {
+				return nil, fmt.Errorf("invalid COLUMN_MAPPING column %q: %w", ref, err)
+			}
+		}
+	}
+	return c, nil
+}
+
+// metadataUploader returns the uploader recorded in a manifest's blob metadata, if any
+func metadataUploader(metadata map[string]*string) string {
+	v := metadataValue(metadata, uploaderMetadataKey)
+	if name, err := url.PathUnescape(v); err == nil {
+		return name
+	}
+	return v
+}
+
+// forUploader returns the mapping for the manifests of uploader: the deployment's
+// mapping with the uploader's overrides applied field by field
+func (c *columnMappingConfig) forUploader(uploader string) columnMapping {
+	m := c.columnMapping
+	for name, o := range c.Uploaders {
+		if uploader == "" || !strings.EqualFold(name, uploader) {
+			continue
+		}
+		if len(o.Sheets) > 0 {
+			m.Sheets = o.Sheets
+		}
+		if o.HeaderRow > 0 {
+			m.HeaderRow = o.HeaderRow
+		}
+		if o.URL != "" {
+			m.URL = o.URL
+		}
+		if o.Tier != "" {
+			m.Tier = o.Tier
+		}
+		if o.Priority != "" {
+			m.Priority = o.Priority
+		}
+		if o.Destination != "" {
+			m.Destination = o.Destination
+		}
+	}
+	return m
+}
+
+// columnLetterIndex returns the zero-based index of a "$C" style column reference, or
+// -1 when ref is not one and names a header instead
+func columnLetterIndex(ref string) (int, error) {
+	letters, ok := strings.CutPrefix(strings.TrimSpace(ref), "$")
+	if !ok {
+		return -1, nil
+	}
+	col, err := excelize.ColumnNameToNumber(letters)
+	if err != nil {
+		return -1, err
+	}
+	return col - 1, nil
+}
+
+// mappedColumn returns the index of the column ref names in headers, -1 when the named
+// header is missing. It returns false when ref is empty, leaving the column to the
+// heuristics.
+func mappedColumn(headers []string, ref string) (int, bool) {
+	if ref == "" {
+		return -1, false
+	}
+	if col, err := columnLetterIndex(ref); err == nil && col != -1 {
+		return col, true
+	}
+	return findHeaderColumn(headers, []string{strings.ToLower(strings.TrimSpace(ref))}), true
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+)
+
+// blobLocation identifies a blob by storage account, container and decoded path
+type blobLocation struct {
+	Account   string `json:"account"`
+	Container string `json:"container"`
+	Path      string `json:"path"`
+	// Endpoint is the account's blob service URL; empty means the default for Account
+	Endpoint string `json:"endpoint,omitempty"`
+	// VersionID or Snapshot select a previous version or a snapshot of the blob; both
+	// empty means the base blob
+	VersionID string `json:"versionId,omitempty"`
+	Snapshot  string `json:"snapshot,omitempty"`
+}
+
+// String returns the location as account/container/path, followed by the version or
+// snapshot query when it names one
+func (l blobLocation) String() string {
+	s := fmt.Sprintf("%s/%s/%s", l.Account, l.Container, l.Path)
+	switch {
+	case l.VersionID != "":
+		s += "?versionid=" + l.VersionID
+	case l.Snapshot != "":
+		s += "?snapshot=" + l.Snapshot
+	}
+	return s
+}
+
+// query returns the encoded versionid or snapshot query of the location, if any
+func (l blobLocation) query() string {
+	switch {
+	case l.VersionID != "":
+		return url.Values{"versionid": {l.VersionID}}.Encode()
+	case l.Snapshot != "":
+		return url.Values{"snapshot": {l.Snapshot}}.Encode()
+	}
+	return ""
+}
+
+// base returns the location of the base blob, without a version or snapshot
+func (l blobLocation) base() blobLocation {
+	l.VersionID, l.Snapshot = "", ""
+	return l
+}
+
+// endpoint returns the blob service URL of the location's account
+func (l blobLocation) endpoint() string {
+	if l.Endpoint != "" {
+		return l.Endpoint
+	}
+	return endpoints.accountEndpoint(l.Account)
+}
+
+// URL returns the blob URL, encoding the container and each path segment once
+func (l blobLocation) URL() string {
+	u := fmt.Sprintf("%s/%s/%s", l.endpoint(), url.PathEscape(l.Container), escapeBlobPath(l.Path))
+	if q := l.query(); q != "" {
+		u += "?" + q
+	}
+	return u
+}
+
+// destinationHeaders are the header names recognised for the optional copy destination column
+var destinationHeaders = []string{"destination", "destination_url", "destination url", "copy_to", "copy to"}
+
+// copySourceSASLifetime bounds how long a cross-account copy may read its source.
+// Standard priority rehydration can take up to 15 hours.
+const copySourceSASLifetime = 24 * time.Hour
+
+// parseDestination resolves a destination cell against the source blob. The value may be
+// a blob endpoint URL (https://<account>.blob.core.windows.net/[container[/path]], or any
+// host parseBlobURL accepts) or a container[/path] in the source account. A missing path,
+// or one ending in "/", keeps the source blob path under that prefix. The destination is
+// always a base blob, even when the source is a version or snapshot. An empty value
+// returns nil.
+func parseDestination(value string, source blobLocation) (*blobLocation, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	dest := source.base()
+	var container, blobPath string
+	if strings.Contains(value, "://") {
+		parsed, err := parseBlobURL(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid destination %q: %w", value, err)
+		}
+		if parsed.VersionID != "" || parsed.Snapshot != "" {
+			return nil, fmt.Errorf("invalid destination %q: cannot copy to a version or snapshot", value)
+		}
+		dest.Account, dest.Endpoint = parsed.Account, parsed.Endpoint
+		container, blobPath = parsed.Container, parsed.Path
+	} else {
+		container, blobPath, _ = strings.Cut(strings.TrimPrefix(value, "/"), "/")
+	}
+
+	if container == "" {
+		return &dest, nil
+	}
+
+	dest.Container = container
+	if blobPath == "" || strings.HasSuffix(blobPath, "/") {
+		dest.Path = blobPath + source.Path
+	} else {
+		dest.Path = blobPath
+	}
+
+	if dest == source.base() {
+		return nil, fmt.Errorf("destination %q is the source blob", value)
+	}
+	return &dest, nil
+}
+
+// copyBlobTier rehydrates a blob by copying it to req.Destination at the requested
+// tier and priority, leaving the source blob untouched
+func copyBlobTier(ctx context.Context, source blobLocation, currentTier blob.AccessTier, req tierRequest) (tierResult, error) {
+	dest := *req.Destination
+
+	targetTier := req.TargetTier
+	if targetTier == "" {
+		targetTier = currentTier
+		if currentTier == blob.AccessTierArchive {
+			targetTier = blob.AccessTierCool
+		}
+	}
+
+	if req.DryRun {
+		status := fmt.Sprintf("Would copy: %s → %s to %s", currentTier, targetTier, dest)
+		if currentTier == blob.AccessTierArchive && req.Priority != "" {
+			status = fmt.Sprintf("%s (%s priority)", status, req.Priority)
+		}
+		return tierResult{Code: resultWouldChange, Status: status, Changed: true, FromTier: currentTier, ToTier: targetTier, Destination: &dest}, nil
+	}
+
+	var priority blob.RehydratePriority
+	if currentTier == blob.AccessTierArchive && req.Priority != "" {
+		priority = req.Priority
+	}
+
+	info, err := storage.StartCopy(ctx, source, dest, targetTier, priority)
+	if err != nil {
+		return tierResult{Status: "Error: Failed to start copy", FromTier: currentTier}, err
+	}
+
+	copyID, copyStatus := info.ID, info.Status
+	log.Printf("Started copy %s: %s → %s (%s)", copyID, source, dest, copyStatus)
+
+	return tierResult{
+		Code:        copyResultCode(string(copyStatus)),
+		Status:      copyStatusText(currentTier, targetTier, dest, copyID, string(copyStatus), ""),
+		Changed:     true,
+		FromTier:    currentTier,
+		ToTier:      targetTier,
+		Priority:    priority,
+		Pending:     copyStatus == blob.CopyStatusTypePending,
+		Destination: &dest,
+		CopyID:      copyID,
+	}, nil
+}
+
+// copyResultCode returns the result code of a copy in the given copy status
+func copyResultCode(copyStatus string) string {
+	switch blob.CopyStatusType(copyStatus) {
+	case blob.CopyStatusTypeSuccess:
+		return resultCopied
+	case blob.CopyStatusTypeFailed, blob.CopyStatusTypeAborted:
+		return resultCopyFailed
+	default:
+		return resultCopying
+	}
+}
+
+// copyStatusText formats the status of a copy-based rehydration, including its copy ID
+// and, when known, the bytes copied so far
+func copyStatusText(fromTier, toTier blob.AccessTier, dest blobLocation, copyID, copyStatus, progress string) string {
+	details := fmt.Sprintf("copy %s, %s", copyID, copyStatus)
+	if progress != "" {
+		details = fmt.Sprintf("%s, %s bytes", details, progress)
+	}
+
+	switch blob.CopyStatusType(copyStatus) {
+	case blob.CopyStatusTypeSuccess:
+		return fmt.Sprintf("Copied: %s → %s to %s (%s)", fromTier, toTier, dest, details)
+	case blob.CopyStatusTypeFailed, blob.CopyStatusTypeAborted:
+		return fmt.Sprintf("Error: Copy %s → %s to %s did not complete (%s)", fromTier, toTier, dest, details)
+	default:
+		return fmt.Sprintf("Copying: %s → %s to %s (%s)", fromTier, toTier, dest, details)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseDestination(t *testing.T) {
+	useEndpoints(t, defaultEndpoints(t))
+
+	source := blobLocation{Account: "acct", Container: "archive", Path: "dir/a.txt", Endpoint: "https://acct.blob.core.windows.net"}
+	version := source
+	version.VersionID = "2024-01-01T00:00:00.0000000Z"
+
+	tests := []struct {
+		name   string
+		value  string
+		source blobLocation
+		want   *blobLocation
+	}{
+		{
+			name:   "empty",
+			value:  " ",
+			source: source,
+		},
+		{
+			name:   "container in the source account",
+			value:  "restored",
+			source: source,
+			want:   &blobLocation{Account: "acct", Container: "restored", Path: "dir/a.txt", Endpoint: "https://acct.blob.core.windows.net"},
+		},
+		{
+			name:   "prefix in the source account",
+			value:  "/restored/2024/",
+			source: source,
+			want:   &blobLocation{Account: "acct", Container: "restored", Path: "2024/dir/a.txt", Endpoint: "https://acct.blob.core.windows.net"},
+		},
+		{
+			name:   "blob in the source account",
+			value:  "restored/b.txt",
+			source: source,
+			want:   &blobLocation{Account: "acct", Container: "restored", Path: "b.txt", Endpoint: "https://acct.blob.core.windows.net"},
+		},
+		{
+			name:   "other account",
+			value:  "https://other.blob.core.windows.net/restored/",
+			source: source,
+			want:   &blobLocation{Account: "other", Container: "restored", Path: "dir/a.txt", Endpoint: "https://other.blob.core.windows.net"},
+		},
+		{
+			name:   "other account only",
+			value:  "https://other.blob.core.windows.net/",
+			source: source,
+			want:   &blobLocation{Account: "other", Container: "archive", Path: "dir/a.txt", Endpoint: "https://other.blob.core.windows.net"},
+		},
+		{
+			name:   "base blob of a version",
+			value:  "restored",
+			source: version,
+			want:   &blobLocation{Account: "acct", Container: "restored", Path: "dir/a.txt", Endpoint: "https://acct.blob.core.windows.net"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDestination(tt.value, tt.source)
+			if err != nil {
+				t.Fatalf("parseDestination(%q): %v", tt.value, err)
+			}
+			switch {
+			case got == nil && tt.want == nil:
+			case got == nil || tt.want == nil || *got != *tt.want:
+				t.Errorf("parseDestination(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDestinationErrors(t *testing.T) {
+	useEndpoints(t, defaultEndpoints(t))
+
+	source := blobLocation{Account: "acct", Container: "archive", Path: "dir/a.txt", Endpoint: "https://acct.blob.core.windows.net"}
+	for _, value := range []string{
+		"archive/dir/a.txt",
+		"https://acct.blob.core.windows.net/archive/dir/a.txt",
+		"https://other.blob.core.windows.net/c/a.txt?versionid=v",
+		"https://example.com/c/a.txt",
+	} {
+		if dest, err := parseDestination(value, source); err == nil {
+			t.Errorf("parseDestination(%q) = %+v, want an error", value, dest)
+		}
+	}
+}
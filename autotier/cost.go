@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+)
+
+// prices is the price table cost estimates are made with, set up in main
+var prices *priceTable
+
+// priceTable holds the charges used to estimate the cost of tier actions. Per-tier
+// values are keyed by access tier name; a tier missing from a map costs nothing.
+type priceTable struct {
+	Currency string `json:"currency"`
+	// StoragePerGBMonth is the capacity charge, used to prorate early deletion
+	StoragePerGBMonth map[blob.AccessTier]float64 `json:"storagePerGBMonth"`
+	// RetrievalPerGB is the data retrieval charge for reading out of a tier, and
+	// HighPriorityRetrievalPerGB its High priority rehydration counterpart
+	RetrievalPerGB             map[blob.AccessTier]float64 `json:"retrievalPerGB"`
+	HighPriorityRetrievalPerGB map[blob.AccessTier]float64 `json:"highPriorityRetrievalPerGB"`
+	// ReadPer10K and WritePer10K are the operation charges per 10,000 requests
+	ReadPer10K             map[blob.AccessTier]float64 `json:"readPer10K"`
+	HighPriorityReadPer10K map[blob.AccessTier]float64 `json:"highPriorityReadPer10K"`
+	WritePer10K            map[blob.AccessTier]float64 `json:"writePer10K"`
+	// MinimumDays is the minimum storage duration before a blob may leave a tier
+	// without an early deletion charge
+	MinimumDays map[blob.AccessTier]float64 `json:"minimumDays"`
+}
+
+// defaultPriceTable returns list prices for locally redundant storage in a typical
+// region, in USD. Deployments with other prices set PRICE_TABLE.
+func defaultPriceTable() *priceTable {
+	return &priceTable{
+		Currency: "USD",
+		StoragePerGBMonth: map[blob.AccessTier]float64{
+			blob.AccessTierHot: 0.0184, blob.AccessTierCool: 0.01, blob.AccessTierCold: 0.0036, blob.AccessTierArchive: 0.00099,
+		},
+		RetrievalPerGB: map[blob.AccessTier]float64{
+			blob.AccessTierCool: 0.01, blob.AccessTierCold: 0.03, blob.AccessTierArchive: 0.02,
+		},
+		HighPriorityRetrievalPerGB: map[blob.AccessTier]float64{
+			blob.AccessTierArchive: 0.10,
+		},
+		ReadPer10K: map[blob.AccessTier]float64{
+			blob.AccessTierHot: 0.0044, blob.AccessTierCool: 0.01, blob.AccessTierCold: 0.10, blob.AccessTierArchive: 5.50,
+		},
+		HighPriorityReadPer10K: map[blob.AccessTier]float64{
+			blob.AccessTierArchive: 66.00,
+		},
+		WritePer10K: map[blob.AccessTier]float64{
+			blob.AccessTierHot: 0.055, blob.AccessTierCool: 0.10, blob.AccessTierCold: 0.18, blob.AccessTierArchive: 0.11,
+		},
+		MinimumDays: map[blob.AccessTier]float64{
+			blob.AccessTierCool: 30, blob.AccessTierCold: 90, blob.AccessTierArchive: 180,
+		},
+	}
+}
+
+// loadPriceTable returns the default price table, overridden by the JSON file named by
+// PRICE_TABLE. The file only needs the values that differ from the defaults.
+func loadPriceTable() (*priceTable, error) {
+	p := defaultPriceTable()
+	path := os.Getenv("PRICE_TABLE")
+	if path == "" {
+		return p, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// costEstimate is the estimated charge of tier actions, in the price table's currency
+type costEstimate struct {
+	SizeGB float64 `json:"sizeGB"`
+	// Read is the retrieval and read operation charge of moving a blob out of Cool or
+	// Cold, and Rehydration the same for moving it out of Archive
+	Read          float64 `json:"read"`
+	Rehydration   float64 `json:"rehydration"`
+	EarlyDeletion float64 `json:"earlyDeletion"`
+	// Total adds the write operation charged for moves to a cooler tier and copies
+	Total float64 `json:"total"`
+}
+
+// add adds the charges of o to c
+func (c *costEstimate) add(o *costEstimate) {
+	if o == nil {
+		return
+	}
+	c.SizeGB += o.SizeGB
+	c.Read += o.Read
+	c.Rehydration += o.Rehydration
+	c.EarlyDeletion += o.EarlyDeletion
+	c.Total += o.Total
+}
+
+// String summarises the estimate for the note on the cost header
+func (c costEstimate) String() string {
+	return fmt.Sprintf("read: %s, rehydration: %s, early deletion: %s, total: %s %s for %.2f GB",
+		formatCost(c.Read), formatCost(c.Rehydration), formatCost(c.EarlyDeletion), formatCost(c.Total), prices.Currency, c.SizeGB)
+}
+
+// tierRank orders access tiers from hottest to coolest
+func tierRank(tier blob.AccessTier) int {
+	switch tier {
+	case blob.AccessTierHot:
+		return 0
+	case blob.AccessTierCool:
+		return 1
+	case blob.AccessTierCold:
+		return 2
+	case blob.AccessTierArchive:
+		return 3
+	}
+	return -1
+}
+
+// estimate returns the cost of the tier action described by result on a blob with
+// props, or nil when the action changes nothing. Moves to a hotter tier and copies pay
+// the source tier's retrieval and read charges at the rehydrate priority; moves to a
+// cooler tier and copies pay a write at the target tier. Leaving a tier in place
+// before its minimum duration pays for the remaining days.
+func (p *priceTable) estimate(props blobProperties, result tierResult, priority blob.RehydratePriority, now time.Time) *costEstimate {
+	if !result.Changed {
+		return nil
+	}
+
+	from, to := result.FromTier, result.ToTier
+	copying := result.Destination != nil
+	gb := float64(props.ContentLength) / (1 << 30)
+	c := &costEstimate{SizeGB: gb}
+
+	if copying || tierRank(to) < tierRank(from) {
+		retrieval, read := p.RetrievalPerGB[from], p.ReadPer10K[from]
+		if from == blob.AccessTierArchive && priority == blob.RehydratePriorityHigh {
+			retrieval, read = p.HighPriorityRetrievalPerGB[from], p.HighPriorityReadPer10K[from]
+		}
+		charge := retrieval*gb + read/10000
+		if from == blob.AccessTierArchive {
+			c.Rehydration = charge
+		} else {
+			c.Read = charge
+		}
+	}
+
+	var write float64
+	if copying || tierRank(to) > tierRank(from) {
+		write = p.WritePer10K[to] / 10000
+	}
+
+	if !copying {
+		c.EarlyDeletion = p.earlyDeletion(from, props, gb, now)
+	}
+
+	c.Total = c.Read + c.Rehydration + c.EarlyDeletion + write
+	return c
+}
+
+// earlyDeletion returns the charge for the days a blob falls short of the minimum
+// duration of tier, counted from its last tier change or, failing that, its last write
+func (p *priceTable) earlyDeletion(tier blob.AccessTier, props blobProperties, gb float64, now time.Time) float64 {
+	minimum := p.MinimumDays[tier]
+	if minimum <= 0 {
+		return 0
+	}
+
+	since := props.LastModified
+	if props.AccessTierChangeTime != nil {
+		since = *props.AccessTierChangeTime
+	}
+	if since.IsZero() {
+		return 0
+	}
+
+	remaining := minimum - now.Sub(since).Hours()/24
+	if remaining <= 0 {
+		return 0
+	}
+	return remaining / 30 * p.StoragePerGBMonth[tier] * gb
+}
+
+// formatCost formats a charge for the note on the cost header
+func formatCost(v float64) string {
+	return fmt.Sprintf("%.4f", v)
+}
+
+// roundCost rounds a charge to six decimal places, enough for single operations
+func roundCost(v float64) float64 {
+	return math.Round(v*1e6) / 1e6
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// processedEvents records handled Event Grid deliveries, set up in main
+var processedEvents *eventStore
+
+// eventRetention is how long handled event IDs and ETags are remembered. Event Grid
+// stops redelivering an event after 24 hours by default.
+const eventRetention = 7 * 24 * time.Hour
+
+// eventStore remembers which Event Grid event IDs and input blob versions have been
+// processed, so at-least-once deliveries do not process a manifest twice
+type eventStore struct {
+	path string
+
+	mu    sync.Mutex
+	state eventState
+	// claimed holds the event IDs queued or running in this process
+	claimed map[string]bool
+}
+
+// eventState is the persisted part of an eventStore
+type eventState struct {
+	// Events maps handled event IDs to when they were handled
+	Events map[string]time.Time `json:"events"`
+	// Blobs maps handled input blob versions (see blobVersionKey) to when they were handled
+	Blobs map[string]time.Time `json:"blobs"`
+}
+
+// processedEventsPath returns the file the event store is persisted to
+func processedEventsPath() string {
+	return filepath.Join(stateDir(), "processed-events.json")
+}
+
+// openEventStore loads the store persisted at path, starting empty if there is none
+func openEventStore(path string) (*eventStore, error) {
+	s := &eventStore{
+		path:    path,
+		state:   eventState{Events: make(map[string]time.Time), Blobs: make(map[string]time.Time)},
+		claimed: make(map[string]bool),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.state); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if s.state.Events == nil {
+		s.state.Events = make(map[string]time.Time)
+	}
+	if s.state.Blobs == nil {
+		s.state.Blobs = make(map[string]time.Time)
+	}
+
+	log.Printf("Loaded %d handled events and %d handled blob versions from %s", len(s.state.Events), len(s.state.Blobs), path)
+	return s, nil
+}
+
+// blobVersionKey identifies one version of an input blob
+func blobVersionKey(blobURL, etag string) string {
+	return blobURL + "#" + etag
+}
+
+// claimEvent reserves an event ID for processing. It returns false when the event
+// has already been handled or is queued or running; empty IDs are always claimed.
+func (s *eventStore) claimEvent(id string) bool {
+	if id == "" {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.state.Events[id]; ok || s.claimed[id] {
+		return false
+	}
+	s.claimed[id] = true
+	return true
+}
+
+// releaseEvent drops a claim without recording the event, so a redelivery is processed
+func (s *eventStore) releaseEvent(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.claimed, id)
+}
+
+// completeEvent records a claimed event as handled
+func (s *eventStore) completeEvent(id string) error {
+	if id == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.claimed, id)
+	s.state.Events[id] = time.Now().UTC()
+	return s.save()
+}
+
+// handledBlob reports whether this version of an input blob has been processed
+func (s *eventStore) handledBlob(blobURL, etag string) bool {
+	if etag == "" {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.state.Blobs[blobVersionKey(blobURL, etag)]
+	return ok
+}
+
+// recordBlob records this version of an input blob as processed
+func (s *eventStore) recordBlob(blobURL, etag string) error {
+	if etag == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.Blobs[blobVersionKey(blobURL, etag)] = time.Now().UTC()
+	return s.save()
+}
+
+// save prunes entries older than eventRetention and persists the store. The caller holds s.mu.
+func (s *eventStore) save() error {
+	cutoff := time.Now().Add(-eventRetention)
+	for _, entries := range []map[string]time.Time{s.state.Events, s.state.Blobs} {
+		for key, handledAt := range entries {
+			if handledAt.Before(cutoff) {
+				delete(entries, key)
+			}
+		}
+	}
+	return writeJSONFile(s.path, s.state)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestEventStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "processed-events.json")
+	s, err := openEventStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.claimEvent("e1") {
+		t.Fatal("claimEvent refused a new event")
+	}
+	if s.claimEvent("e1") {
+		t.Error("claimEvent accepted an event that is already queued")
+	}
+	s.releaseEvent("e1")
+	if !s.claimEvent("e1") {
+		t.Error("claimEvent refused a released event")
+	}
+	if err := s.completeEvent("e1"); err != nil {
+		t.Fatal(err)
+	}
+	if !s.claimEvent("") || !s.claimEvent("") {
+		t.Error("claimEvent refused an event without an ID")
+	}
+
+	const blobURL = "https://acct.blob.core.windows.net/in/a.xlsx"
+	if err := s.recordBlob(blobURL, `"0x1"`); err != nil {
+		t.Fatal(err)
+	}
+	if s.handledBlob(blobURL, "") {
+		t.Error("handledBlob matched a blob without an ETag")
+	}
+
+	// Handled events and blob versions survive a restart
+	reopened, err := openEventStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.claimEvent("e1") {
+		t.Error("claimEvent accepted an event handled before the restart")
+	}
+	if !reopened.handledBlob(blobURL, `"0x1"`) {
+		t.Error("handledBlob forgot a version processed before the restart")
+	}
+	if reopened.handledBlob(blobURL, `"0x2"`) {
+		t.Error("handledBlob matched a new version of the blob")
+	}
+}
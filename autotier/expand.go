@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/xuri/excelize/v2"
+)
+
+// expansionSheetName is the name of the worksheet listing the blobs matched by prefix rows
+const expansionSheetName = "Expanded"
+
+// expansionHeaders are the leading columns of the expansion sheet, before resultHeaders
+var expansionHeaders = []string{"Source Sheet", "Source Row", "Prefix", "Blob"}
+
+// prefixRow is a manifest row naming several blobs, queued as one task per match
+type prefixRow struct {
+	RowIndex int
+	// Value is the manifest cell, and Prefix the location listed for it
+	Value  string
+	Prefix blobLocation
+	Tasks  []rowTask
+	// Err is set when the prefix could not be listed; no blob is touched
+	Err error
+}
+
+// parseBlobPrefix parses a manifest location naming several blobs: a container URL, a
+// URL ending in "/" (a virtual directory) or one ending in "*" (a name prefix). The
+// returned location's Path is the prefix to list. ok is false for anything else, which
+// is left to parseBlobRef.
+func parseBlobPrefix(rawURL string) (blobLocation, bool, error) {
+	loc, err := parseBlobURL(rawURL)
+	if err != nil || loc.Container == "" {
+		return blobLocation{}, false, nil
+	}
+
+	switch {
+	case strings.HasSuffix(loc.Path, "*"):
+		loc.Path = strings.TrimSuffix(loc.Path, "*")
+	case loc.Path == "" || strings.HasSuffix(loc.Path, "/"):
+	default:
+		return blobLocation{}, false, nil
+	}
+
+	if loc.VersionID != "" || loc.Snapshot != "" {
+		return loc, true, fmt.Errorf("invalid blob prefix %q: a version or snapshot names a single blob", rawURL)
+	}
+	return loc, true, nil
+}
+
+// expandPrefixRow lists the blobs matching prefix and returns a task for each, with
+// the row's tier, priority and destination options. A prefix matching more than
+// opts.MaxPrefixBlobs blobs fails as a whole rather than being partly processed.
+func expandPrefixRow(ctx context.Context, row []string, columns manifestColumns, prefix blobLocation, defaultTier blob.AccessTier, defaultPriority blob.RehydratePriority, opts processOptions) ([]rowTask, error) {
+	items, err := storage.List(ctx, prefix, opts.MaxPrefixBlobs+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) > opts.MaxPrefixBlobs {
+		return nil, fmt.Errorf("%s matches more than %d blobs; split the row or raise MAX_PREFIX_BLOBS", prefix, opts.MaxPrefixBlobs)
+	}
+
+	tasks := make([]rowTask, 0, len(items))
+	for _, item := range items {
+		req, err := rowTierRequest(row, columns, item.Location, defaultTier, defaultPriority)
+		req.DryRun = opts.DryRun
+		tasks = append(tasks, rowTask{Source: item.Location, Req: req, Err: err})
+	}
+	return tasks, nil
+}
+
+// expansionSheet is the worksheet listing the blobs matched by prefix rows, one row
+// each, linked back to the manifest row that named the prefix
+type expansionSheet struct {
+	f    *excelize.File
+	Name string
+	// ResultCol is the zero-based first result column
+	ResultCol int
+	// next is the zero-based row the next blob is written to
+	next  int
+	links *hyperlinker
+}
+
+// uniqueSheetName returns name, or name with a numeric suffix when f already has a
+// worksheet of that name
+func uniqueSheetName(f *excelize.File, name string) string {
+	unique := name
+	for i := 2; ; i++ {
+		if index, err := f.GetSheetIndex(unique); err != nil || index == -1 {
+			return unique
+		}
+		unique = fmt.Sprintf("%s %d", name, i)
+	}
+}
+
+// newExpansionSheet adds the expansion sheet to f with its header row
+func newExpansionSheet(f *excelize.File) (*expansionSheet, error) {
+	name := uniqueSheetName(f, expansionSheetName)
+	if _, err := f.NewSheet(name); err != nil {
+		return nil, err
+	}
+
+	headers := make([]interface{}, len(expansionHeaders))
+	for i, h := range expansionHeaders {
+		headers[i] = h
+	}
+	if err := f.SetSheetRow(name, "A1", &headers); err != nil {
+		return nil, err
+	}
+
+	links, err := newHyperlinker(f, name)
+	if err != nil {
+		return nil, err
+	}
+	e := &expansionSheet{f: f, Name: name, ResultCol: len(expansionHeaders), next: 1, links: links}
+	if err := writeResultHeaders(f, name, 0, e.ResultCol); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// add writes a blob matched by the prefix row at the zero-based originRow of
+// sheetName, linking the Source Row cell to that row and the Blob cell to the blob,
+// and returns the blob's zero-based row
+func (e *expansionSheet) add(sheetName string, originRow int, p *prefixRow, source blobLocation, r rowResult) (int, error) {
+	row := e.next
+	cell, err := excelize.CoordinatesToCellName(1, row+1)
+	if err != nil {
+		return 0, err
+	}
+	values := []interface{}{sheetName, originRow + 1, p.Value, source.String()}
+	if err := e.f.SetSheetRow(e.Name, cell, &values); err != nil {
+		return 0, err
+	}
+
+	link := fmt.Sprintf("'%s'!A%d", strings.ReplaceAll(sheetName, "'", "''"), originRow+1)
+	if err := e.links.link(row, 1, link, "Location"); err != nil {
+		return 0, err
+	}
+	if err := e.links.link(row, 3, source.URL(), "External"); err != nil {
+		return 0, err
+	}
+
+	if err := writeRowResult(e.f, e.Name, row, e.ResultCol, r); err != nil {
+		return 0, err
+	}
+	e.next++
+	return row, nil
+}
+
+// style styles the expansion sheet once every blob has been added
+func (e *expansionSheet) style() error {
+	headerStyle, err := newHeaderStyle(e.f)
+	if err != nil {
+		return err
+	}
+	last, err := excelize.CoordinatesToCellName(len(expansionHeaders), 1)
+	if err != nil {
+		return err
+	}
+	if err := e.f.SetCellStyle(e.Name, "A1", last, headerStyle); err != nil {
+		return err
+	}
+	if err := e.f.SetColWidth(e.Name, "A", "D", 20); err != nil {
+		return err
+	}
+	return styleResultSheet(e.f, e.Name, 0, e.ResultCol, e.next)
+}
+
+// writePrefixResults writes each blob matched by a prefix row to expansion and the
+// totals to the prefix row's own result columns, adding them to sheet and sheetCost.
+// Without an expansion sheet, as for CSV and JSON manifests, only the totals are
+// written and the prefix row reports how many of its blobs' rehydrations finished.
+func writePrefixResults(f *excelize.File, plan *sheetPlan, p *prefixRow, outcomes []rowOutcome, expansion *expansionSheet, changedKey string, opts processOptions, sheet *sheetReport, sheetCost *costEstimate) []rehydrationRecord {
+	stats := newStats(changedKey)
+	var cost costEstimate
+	var rehydrations []rehydrationRecord
+	label := fmt.Sprintf("%s row %d", plan.Name, p.RowIndex+1)
+
+	total := rowResult{Code: resultExpanded, Timestamp: time.Now().UTC(), ResolvedURL: p.Prefix.URL()}
+	switch {
+	case p.Err != nil:
+		stats["errors"]++
+		code, message := classifyError(p.Err)
+		total.Code, total.Error = code, "List blobs: "+message
+		sheet.Rows = append(sheet.Rows, newRowSummary(plan.Name, p.RowIndex, plan.ResultColIndex, p.Prefix, total, "errors"))
+	case len(p.Tasks) == 0:
+		stats["skipped"]++
+		total.Code, total.Details = resultSkipped, "Skipped: No blobs match the prefix"
+		sheet.Rows = append(sheet.Rows, newRowSummary(plan.Name, p.RowIndex, plan.ResultColIndex, p.Prefix, total, "skipped"))
+	default:
+		firstRow, lastRow := -1, -1
+		for i, task := range p.Tasks {
+			rr, outcome := tallyOutcome(stats, &cost, changedKey, task, outcomes[i], fmt.Sprintf("%s %s", label, task.Source))
+			if expansion == nil {
+				sheet.Rows = append(sheet.Rows, newRowSummary(plan.Name, p.RowIndex, plan.ResultColIndex, task.Source, rr, outcome))
+				if outcomes[i].Result.Pending && !opts.DryRun {
+					r := newRehydrationRecord(task, outcomes[i].Result, rr, plan.Name, p.RowIndex, plan.ResultColIndex)
+					r.Expanded = true
+					rehydrations = append(rehydrations, r)
+				}
+				continue
+			}
+
+			row, err := expansion.add(plan.Name, p.RowIndex, p, task.Source, rr)
+			if err != nil {
+				stats["errors"]++
+				log.Printf("%s %s: Failed to write result: %v", label, task.Source, err)
+				continue
+			}
+			sheet.Rows = append(sheet.Rows, newRowSummary(expansion.Name, row, expansion.ResultCol, task.Source, rr, outcome))
+			if firstRow == -1 {
+				firstRow = row
+			}
+			lastRow = row
+
+			if outcomes[i].Result.Pending && !opts.DryRun {
+				rehydrations = append(rehydrations, newRehydrationRecord(task, outcomes[i].Result, rr, expansion.Name, row, expansion.ResultCol))
+			}
+		}
+
+		total.Details = fmt.Sprintf("Expanded: %d blobs (%s)", len(p.Tasks), formatStats(stats))
+		if firstRow != -1 {
+			total.Details += fmt.Sprintf(", rows %d–%d of %s", firstRow+1, lastRow+1, expansion.Name)
+		}
+		if expansion == nil && len(rehydrations) > 0 {
+			for i := range rehydrations {
+				rehydrations[i].PrefixDetails = total.Details
+			}
+			total.Details = prefixRehydrationDetails(total.Details, 0, len(rehydrations))
+		}
+		if stats["errors"] > 0 {
+			total.Error = fmt.Sprintf("%d of %d blobs failed", stats["errors"], len(p.Tasks))
+		}
+		if cost.SizeGB > 0 || cost.Total > 0 {
+			total.Cost = &cost
+		}
+	}
+
+	if err := writeRowResult(f, plan.Name, p.RowIndex, plan.ResultColIndex, total); err != nil {
+		stats["errors"]++
+		log.Printf("%s: Failed to write result: %v", label, err)
+	}
+
+	sheetCost.add(&cost)
+	for key, count := range stats {
+		sheet.Stats[key] += count
+	}
+	return rehydrations
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// errQueueFull and errQueueClosed are returned by enqueue when a job cannot be accepted.
+// The webhook reports both as 503 so Event Grid delivers the event again later.
+var (
+	errQueueFull   = errors.New("job queue is full")
+	errQueueClosed = errors.New("job queue is shutting down")
+)
+
+// jobs is the queue /process hands manifests to
+var jobs *jobQueue
+
+// job is a manifest waiting to be processed by a background worker
+type job struct {
+	ID       string
+	EventID  string
+	BlobURL  string
+	QueuedAt time.Time
+}
+
+// jobQueue runs queued jobs on a fixed set of background workers
+type jobQueue struct {
+	queue chan job
+	wg    sync.WaitGroup
+
+	// ctx is passed to running jobs and cancelled when shutdown gives up waiting
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// newJobQueue creates a queue holding up to size jobs that have not started yet
+func newJobQueue(size int) *jobQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &jobQueue{queue: make(chan job, size), ctx: ctx, cancel: cancel}
+}
+
+// newJob creates a job for blobURL with a fresh ID
+func newJob(eventID, blobURL string) (job, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return job{}, fmt.Errorf("failed to generate job ID: %w", err)
+	}
+	return job{ID: hex.EncodeToString(id), EventID: eventID, BlobURL: blobURL, QueuedAt: time.Now().UTC()}, nil
+}
+
+// start launches the workers; each takes the next queued job when it is free
+func (q *jobQueue) start(workers int) {
+	log.Printf("Starting %d job workers (queue size %d)", workers, cap(q.queue))
+	for w := 1; w <= workers; w++ {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			for j := range q.queue {
+				q.run(w, j)
+			}
+		}()
+	}
+}
+
+// enqueue queues j without blocking
+func (q *jobQueue) enqueue(j job) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return errQueueClosed
+	}
+
+	select {
+	case q.queue <- j:
+		log.Printf("Job %s queued: %s (%d waiting)", j.ID, j.BlobURL, len(q.queue))
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+// resume queues the jobs a previous process left queued or running, oldest first. A
+// job already started maxAttempts times is failed instead, so a manifest that keeps
+// taking the process down is not run forever. The queue must have room for every job.
+func (q *jobQueue) resume(records []jobRecord, maxAttempts int) {
+	for _, r := range records {
+		if r.Attempts >= maxAttempts {
+			err := fmt.Errorf("interrupted by a restart after %d attempts", r.Attempts)
+			if err := jobHistory.finish(r.ID, nil, err); err != nil {
+				log.Printf("Failed to record outcome of job %s: %v", r.ID, err)
+			}
+			continue
+		}
+		if !processedEvents.claimEvent(r.EventID) {
+			// Handled by another job since; recorded as skipped
+			if err := jobHistory.finish(r.ID, nil, nil); err != nil {
+				log.Printf("Failed to record outcome of job %s: %v", r.ID, err)
+			}
+			continue
+		}
+
+		j, err := jobHistory.requeue(r.ID)
+		if err == nil {
+			err = q.enqueue(j)
+		}
+		if err != nil {
+			processedEvents.releaseEvent(r.EventID)
+			log.Printf("Failed to resume job %s: %v", r.ID, err)
+			continue
+		}
+		log.Printf("Resumed job %s left %s by a previous process", r.ID, r.State)
+	}
+}
+
+// run processes one job, logging and recording its outcome. A panic fails the
+// job without taking down the worker.
+func (q *jobQueue) run(worker int, j job) {
+	started := time.Now()
+	log.Printf("Job %s started on worker %d after %s in queue: %s", j.ID, worker, started.Sub(j.QueuedAt).Round(time.Millisecond), j.BlobURL)
+	if err := jobHistory.start(j.ID); err != nil {
+		log.Printf("Failed to record job %s as running: %v", j.ID, err)
+	}
+
+	var report *processReport
+	var err error
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("❌ Job %s panicked: %v\n%s", j.ID, p, debug.Stack())
+			err = fmt.Errorf("panic: %v", p)
+		}
+
+		if err != nil && q.ctx.Err() != nil {
+			// Cancelled by shutdown: the job stays running, so the next process
+			// resumes it
+			log.Printf("Job %s interrupted by shutdown", j.ID)
+			return
+		}
+
+		if err != nil {
+			// Event Grid does not redeliver an accepted event, so a failed job is
+			// left unhandled for POST /jobs/{id}/retry to queue again
+			processedEvents.releaseEvent(j.EventID)
+		} else if err := processedEvents.completeEvent(j.EventID); err != nil {
+			log.Printf("Failed to record event %s as handled: %v", j.EventID, err)
+		}
+
+		if err := jobHistory.finish(j.ID, report, err); err != nil {
+			log.Printf("Failed to record outcome of job %s: %v", j.ID, err)
+		}
+	}()
+
+	report, err = processExcelBlob(q.ctx, j.BlobURL)
+	if err != nil {
+		log.Printf("❌ Job %s failed after %s: %v", j.ID, time.Since(started).Round(time.Millisecond), err)
+		return
+	}
+	log.Printf("✅ Job %s completed in %s", j.ID, time.Since(started).Round(time.Millisecond))
+}
+
+// shutdown stops accepting jobs and waits for the queued and running ones to finish.
+// When ctx ends first, running jobs are cancelled and ctx's error is returned.
+func (q *jobQueue) shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.queue)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		q.cancel()
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestJobQueueEnqueue(t *testing.T) {
+	q := newJobQueue(1)
+
+	first, err := newJob("event-1", "https://acct.blob.core.windows.net/in/a.xlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := newJob("event-2", "https://acct.blob.core.windows.net/in/b.xlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID == second.ID {
+		t.Errorf("two jobs share the ID %s", first.ID)
+	}
+
+	if err := q.enqueue(first); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	// Without workers the queue stays full, and a full queue refuses work at once
+	if err := q.enqueue(second); !errors.Is(err, errQueueFull) {
+		t.Errorf("enqueue on a full queue = %v, want %v", err, errQueueFull)
+	}
+
+	// Without workers shutdown has nothing to wait for
+	if err := q.shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if err := q.enqueue(second); !errors.Is(err, errQueueClosed) {
+		t.Errorf("enqueue after shutdown = %v, want %v", err, errQueueClosed)
+	}
+	if q.ctx.Err() == nil {
+		t.Error("shutdown left the job context running")
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job states, in lifecycle order
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	// jobSkipped is a job whose input blob version had already been processed
+	jobSkipped = "skipped"
+)
+
+// jobRetention is how long finished jobs are kept in the store
+const jobRetention = 30 * 24 * time.Hour
+
+// jobHistory records every job, set up in main
+var jobHistory *jobStore
+
+// jobRecord is the persisted state of a job, as served by the jobs API
+type jobRecord struct {
+	ID         string     `json:"id"`
+	EventID    string     `json:"eventId,omitempty"`
+	InputURL   string     `json:"inputUrl"`
+	State      string     `json:"state"`
+	QueuedAt   time.Time  `json:"queuedAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	// Attempts counts the times the job was started, across retries and restarts
+	Attempts   int            `json:"attempts,omitempty"`
+	DryRun     bool           `json:"dryRun"`
+	OutputFile string         `json:"outputFile,omitempty"`
+	Stats      map[string]int `json:"stats,omitempty"`
+	Sheets     []sheetReport  `json:"sheets,omitempty"`
+	// Cost is the estimated cost of the job's actions, in CostCurrency
+	Cost         *costEstimate `json:"cost,omitempty"`
+	CostCurrency string        `json:"costCurrency,omitempty"`
+}
+
+// jobStore keeps job records in memory and persists each one to its own file
+type jobStore struct {
+	dir string
+
+	mu   sync.RWMutex
+	jobs map[string]*jobRecord
+}
+
+// jobStoreDir returns the directory holding one file per job
+func jobStoreDir() string {
+	return filepath.Join(stateDir(), "jobs")
+}
+
+// openJobStore loads the jobs persisted in dir, dropping finished jobs past
+// jobRetention. Jobs left queued or running by a previous process keep their state
+// until they are resumed, see jobQueue.resume.
+func openJobStore(dir string) (*jobStore, error) {
+	s := &jobStore{dir: dir, jobs: make(map[string]*jobRecord)}
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-jobRetention)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var j jobRecord
+		if err := json.Unmarshal(data, &j); err != nil {
+			log.Printf("Ignoring unreadable job %s: %v", path, err)
+			continue
+		}
+
+		if j.FinishedAt != nil && j.FinishedAt.Before(cutoff) {
+			if err := os.Remove(path); err != nil {
+				log.Printf("Failed to remove expired job %s: %v", path, err)
+			}
+			continue
+		}
+
+		s.jobs[j.ID] = &j
+	}
+
+	log.Printf("Loaded %d jobs from %s", len(s.jobs), dir)
+	return s, nil
+}
+
+// add records a newly queued job
+func (s *jobStore) add(j job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := &jobRecord{ID: j.ID, EventID: j.EventID, InputURL: j.BlobURL, State: jobQueued, QueuedAt: j.QueuedAt}
+	s.jobs[j.ID] = r
+	return s.save(r)
+}
+
+// remove deletes a job that never made it into the queue
+func (s *jobStore) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.jobs, id)
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove job %s: %v", id, err)
+	}
+}
+
+// start marks a job as running
+func (s *jobStore) start(id string) error {
+	return s.update(id, func(r *jobRecord) {
+		now := time.Now().UTC()
+		r.State = jobRunning
+		r.StartedAt = &now
+		r.Attempts++
+	})
+}
+
+// requeue marks a job as queued again, clearing the outcome of its previous attempt,
+// and returns it for the queue
+func (s *jobStore) requeue(id string) (job, error) {
+	var j job
+	err := s.update(id, func(r *jobRecord) {
+		r.State = jobQueued
+		r.StartedAt, r.FinishedAt, r.Error = nil, nil, ""
+		r.Stats, r.Sheets, r.Cost = nil, nil, nil
+		j = job{ID: r.ID, EventID: r.EventID, BlobURL: r.InputURL, QueuedAt: time.Now().UTC()}
+	})
+	return j, err
+}
+
+// unfinished returns copies of the jobs left queued or running, oldest first
+func (s *jobStore) unfinished() []jobRecord {
+	records := append(s.list(jobQueued), s.list(jobRunning)...)
+	slices.SortFunc(records, func(a, b jobRecord) int {
+		return a.QueuedAt.Compare(b.QueuedAt)
+	})
+	return records
+}
+
+// finish records the outcome of a job. A nil report without an error means the
+// input had already been processed.
+func (s *jobStore) finish(id string, report *processReport, jobErr error) error {
+	return s.update(id, func(r *jobRecord) {
+		now := time.Now().UTC()
+		r.FinishedAt = &now
+		switch {
+		case jobErr != nil:
+			r.State = jobFailed
+			r.Error = jobErr.Error()
+		case report == nil:
+			r.State = jobSkipped
+		default:
+			r.State = jobSucceeded
+		}
+		if report != nil {
+			r.DryRun = report.DryRun
+			r.OutputFile = report.OutputFile
+			r.Stats = report.Stats
+			r.Sheets = report.Sheets
+			r.Cost, r.CostCurrency = &report.Cost, report.Currency
+		}
+	})
+}
+
+// update applies fn to a job and persists it
+func (s *jobStore) update(id string, fn func(*jobRecord)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.jobs[id]
+	if !ok {
+		return fmt.Errorf("job %s not found", id)
+	}
+	fn(r)
+	return s.save(r)
+}
+
+// get returns a copy of a job
+func (s *jobStore) get(id string) (jobRecord, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.jobs[id]
+	if !ok {
+		return jobRecord{}, false
+	}
+	return *r, true
+}
+
+// list returns copies of the jobs in state (every job if empty), newest first
+func (s *jobStore) list(state string) []jobRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	records := make([]jobRecord, 0, len(s.jobs))
+	for _, r := range s.jobs {
+		if state == "" || r.State == state {
+			records = append(records, *r)
+		}
+	}
+	slices.SortFunc(records, func(a, b jobRecord) int {
+		return b.QueuedAt.Compare(a.QueuedAt)
+	})
+	return records
+}
+
+// path returns the file a job is persisted to
+func (s *jobStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// save persists a job. The caller holds s.mu.
+func (s *jobStore) save(r *jobRecord) error {
+	return writeJSONFile(s.path(r.ID), r)
+}
+
+// handleListJobs serves GET /jobs, newest first. The optional state parameter
+// filters by job state and limit caps the number of jobs returned (default 100).
+func handleListJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	records := jobHistory.list(r.URL.Query().Get("state"))
+	total := len(records)
+	if len(records) > limit {
+		records = records[:limit]
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"total": total,
+		"jobs":  records,
+	})
+}
+
+// handleGetJob serves GET /jobs/{id}
+func handleGetJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	if id == "" {
+		handleListJobs(w, r)
+		return
+	}
+
+	record, ok := jobHistory.get(id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "job not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, record)
+}
+
+// handleRetryJob serves POST /jobs/{id}/retry, queueing a failed job again. Event Grid
+// does not redeliver an event once it has been accepted, so this is how a manifest
+// that failed, for instance on an outage, is processed after all.
+func handleRetryJob(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	record, ok := jobHistory.get(id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "job not found"})
+		return
+	}
+	if record.State != jobFailed {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "only failed jobs can be retried, job is " + record.State})
+		return
+	}
+	if !processedEvents.claimEvent(record.EventID) {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "the job's event is queued, running or handled by another job"})
+		return
+	}
+
+	j, err := jobHistory.requeue(id)
+	if err != nil {
+		processedEvents.releaseEvent(record.EventID)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if err := jobs.enqueue(j); err != nil {
+		processedEvents.releaseEvent(record.EventID)
+		if err := jobHistory.finish(id, nil, fmt.Errorf("retry not queued: %w", err)); err != nil {
+			log.Printf("Failed to record outcome of job %s: %v", id, err)
+		}
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+		return
+	}
+
+	record, _ = jobHistory.get(id)
+	writeJSON(w, http.StatusAccepted, record)
+}
+
+// writeJSON writes v as the JSON response body with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
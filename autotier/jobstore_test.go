@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJobStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openJobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queuedAt := time.Now().UTC()
+	jobs := []job{
+		{ID: "j1", EventID: "e1", BlobURL: "https://acct.blob.core.windows.net/in/a.xlsx", QueuedAt: queuedAt},
+		{ID: "j2", BlobURL: "https://acct.blob.core.windows.net/in/b.csv", QueuedAt: queuedAt.Add(time.Second)},
+		{ID: "j3", BlobURL: "https://acct.blob.core.windows.net/in/c.json", QueuedAt: queuedAt.Add(2 * time.Second)},
+	}
+	for _, j := range jobs {
+		if err := s.add(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report := &processReport{OutputFile: "a_processed.xlsx", Stats: map[string]int{"changed": 2}}
+	if err := s.start("j1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.finish("j1", report, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.start("j2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.finish("j2", nil, errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.start("j3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.finish("j3", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.start("missing"); err == nil {
+		t.Error("start accepted an unknown job")
+	}
+
+	got, ok := s.get("j1")
+	if !ok {
+		t.Fatal("get did not find j1")
+	}
+	if got.State != jobSucceeded || got.OutputFile != "a_processed.xlsx" || got.Stats["changed"] != 2 || got.StartedAt == nil || got.FinishedAt == nil {
+		t.Errorf("j1 = %+v", got)
+	}
+	if got, _ := s.get("j2"); got.State != jobFailed || got.Error != "boom" {
+		t.Errorf("j2 = %+v", got)
+	}
+	if got, _ := s.get("j3"); got.State != jobSkipped {
+		t.Errorf("j3 state = %q, want %q", got.State, jobSkipped)
+	}
+
+	var ids []string
+	for _, r := range s.list("") {
+		ids = append(ids, r.ID)
+	}
+	if len(ids) != 3 || ids[0] != "j3" || ids[2] != "j1" {
+		t.Errorf("list order = %v, want newest first", ids)
+	}
+	if failed := s.list(jobFailed); len(failed) != 1 || failed[0].ID != "j2" {
+		t.Errorf("list(%q) = %+v", jobFailed, failed)
+	}
+
+	s.remove("j3")
+	if _, ok := s.get("j3"); ok {
+		t.Error("get found a removed job")
+	}
+
+	// Finished jobs survive a restart
+	reopened, err := openJobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := reopened.get("j1"); !ok || got.State != jobSucceeded || got.EventID != "e1" {
+		t.Errorf("reopened j1 = %+v, %v", got, ok)
+	}
+	if len(reopened.list("")) != 2 {
+		t.Errorf("reopened store has %d jobs, want 2", len(reopened.list("")))
+	}
+}
+
+func TestJobStoreKeepsUnfinishedJobs(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openJobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queuedAt := time.Now().UTC()
+	for i, id := range []string{"j1", "j2"} {
+		if err := s.add(job{ID: id, BlobURL: "https://acct.blob.core.windows.net/in/" + id + ".xlsx", QueuedAt: queuedAt.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.start("j2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restart keeps queued and running jobs for the queue to resume, oldest first
+	reopened, err := openJobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unfinished := reopened.unfinished()
+	if len(unfinished) != 2 || unfinished[0].ID != "j1" || unfinished[1].State != jobRunning || unfinished[1].Attempts != 1 {
+		t.Fatalf("unfinished = %+v", unfinished)
+	}
+
+	j, err := reopened.requeue("j2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.ID != "j2" || j.BlobURL != "https://acct.blob.core.windows.net/in/j2.xlsx" {
+		t.Errorf("requeue returned %+v", j)
+	}
+	if got, _ := reopened.get("j2"); got.State != jobQueued || got.StartedAt != nil || got.Attempts != 1 {
+		t.Errorf("requeued j2 = %+v", got)
+	}
+}
@@ -10,13 +10,16 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"regexp"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
-	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
-	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
 	"github.com/xuri/excelize/v2"
 )
 
@@ -25,7 +28,8 @@ type EventGridEvent struct {
 	ID        string `json:"id"`
 	EventType string `json:"eventType"`
 	Data      struct {
-		URL string `json:"url"`
+		URL  string `json:"url"`
+		ETag string `json:"eTag"`
 	} `json:"data"`
 }
 
@@ -43,6 +47,93 @@ type EventGridValidationResponse struct {
 	ValidationResponse string `json:"validationResponse"`
 }
 
+// tierRequest describes the tier change requested for a single blob
+type tierRequest struct {
+	TargetTier blob.AccessTier
+	Priority   blob.RehydratePriority
+	// Destination switches to copy-based rehydration, leaving the source blob in place
+	Destination *blobLocation
+	// DryRun performs the lookups but skips SetTier and copies
+	DryRun bool
+	// Batch defers the SetTier call to a Blob Batch request, see runTierBatches
+	Batch bool
+}
+
+// processOptions controls how processExcelFile treats a manifest
+type processOptions struct {
+	DryRun bool
+	// Workers bounds the rows processed concurrently, and WorkersPerAccount the
+	// concurrent requests against any one storage account
+	Workers           int
+	WorkersPerAccount int
+	// Sheets limits processing to the named worksheets; empty means every sheet
+	Sheets []string
+	// Retry controls the retries of rows failing with transient errors
+	Retry retryPolicy
+	// BatchSize is the most tier changes sent in one Blob Batch request; 1 sends
+	// each change on its own
+	BatchSize int
+	// Columns maps the manifest's header row and columns, overriding the heuristics
+	Columns columnMapping
+	// MaxPrefixBlobs bounds the blobs a single prefix row may expand to
+	MaxPrefixBlobs int
+	// Workbook is set when the output is an Excel workbook, which keeps extra
+	// worksheets and formatting: the blobs of prefix rows are listed on a worksheet of
+	// their own and the processed sheets are styled
+	Workbook bool
+}
+
+// processReport describes what processExcelFile did to a workbook
+type processReport struct {
+	DryRun       bool
+	Stats        map[string]int
+	Sheets       []sheetReport
+	Rehydrations []rehydrationRecord
+	// OutputFile is the name the processed manifest was uploaded as
+	OutputFile string
+	// Cost totals the estimated cost of every row, in Currency
+	Cost     costEstimate
+	Currency string
+	// StartedAt and FinishedAt bound the processing of the manifest
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// sheetReport holds the stats of a single worksheet
+type sheetReport struct {
+	Name  string         `json:"name"`
+	Stats map[string]int `json:"stats"`
+	Cost  costEstimate   `json:"cost"`
+	// Rows lists the outcome of every blob for the Summary sheet
+	Rows []rowSummary `json:"-"`
+}
+
+// dryRunMetadataKey is the blob metadata key the upload service sets to request a dry run
+const dryRunMetadataKey = "dryrun"
+
+// tierResult describes the outcome of a tier check for a single blob
+type tierResult struct {
+	// Code is the Result column value; Status is the human readable Details
+	Code     string
+	Status   string
+	Changed  bool
+	FromTier blob.AccessTier
+	ToTier   blob.AccessTier
+	Priority blob.RehydratePriority
+	// Pending is set when the blob is rehydrating out of Archive after this call
+	Pending       bool
+	ArchiveStatus string
+	Destination   *blobLocation
+	CopyID        string
+	// Version describes the version or snapshot acted on, see versionLabel
+	Version string
+	// Cost is the estimated cost of the action, nil when nothing changes
+	Cost *costEstimate
+	// SetTier is the tier change deferred to a Blob Batch request when the request
+	// allowed it. The rest of the result assumes the change succeeds.
+	SetTier *tierChange
+}
+
 // ReadSeekCloser wraps a bytes.Reader to implement io.ReadSeekCloser
 type ReadSeekCloser struct {
 	*bytes.Reader
@@ -55,22 +146,125 @@ func (r ReadSeekCloser) Close() error {
 
 func main() {
 	http.HandleFunc("/process", handleProcess)
+	http.HandleFunc("/jobs", handleListJobs)
+	http.HandleFunc("/jobs/", handleGetJob)
+	http.HandleFunc("POST /jobs/{id}/retry", handleRetryJob)
 	// Add health check endpoint
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprint(w, "✅ Service is healthy")
 	})
 
+	// Blob hosts accepted in manifests, beyond the public cloud defaults
+	var err error
+	endpoints, err = loadBlobEndpoints()
+	if err != nil {
+		log.Fatalf("Invalid blob endpoint configuration: %v", err)
+	}
+
+	// Prices for the cost estimates, beyond the list price defaults
+	prices, err = loadPriceTable()
+	if err != nil {
+		log.Fatalf("Invalid price table: %v", err)
+	}
+
+	// Where manifests keep their URLs, beyond the header heuristics
+	columnMappings, err = loadColumnMapping()
+	if err != nil {
+		log.Fatalf("Invalid column mapping: %v", err)
+	}
+
+	// One storage backend, with its credential and client cache, for the whole process
+	storage, err = newStorageBackend()
+	if err != nil {
+		log.Fatalf("Failed to set up storage: %v", err)
+	}
+
+	// Re-poll submitted rehydrations and publish updated workbooks as they complete
+	checkInterval := 15 * time.Minute
+	if v := os.Getenv("REHYDRATION_CHECK_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid REHYDRATION_CHECK_INTERVAL %q: %v", v, err)
+		}
+		checkInterval = d
+	}
+	go runRehydrationChecker(checkInterval)
+
+	// Remember handled deliveries so Event Grid retries do not reprocess a manifest
+	processedEvents, err = openEventStore(processedEventsPath())
+	if err != nil {
+		log.Fatalf("Failed to open processed event store: %v", err)
+	}
+
+	// Every queued manifest is recorded as a job, served by /jobs
+	jobHistory, err = openJobStore(jobStoreDir())
+	if err != nil {
+		log.Fatalf("Failed to open job store: %v", err)
+	}
+
+	// Manifests are processed by background workers so /process can return
+	// before Event Grid's delivery timeout
+	workers, err := envInt("JOB_WORKERS", 2)
+	if err != nil {
+		log.Fatal(err)
+	}
+	queueSize, err := envInt("JOB_QUEUE_SIZE", 100)
+	if err != nil {
+		log.Fatal(err)
+	}
+	maxAttempts, err := envInt("JOB_MAX_ATTEMPTS", 3)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Jobs a previous process left queued or running are picked up again
+	unfinished := jobHistory.unfinished()
+	jobs = newJobQueue(max(queueSize, len(unfinished)))
+	jobs.start(workers)
+	jobs.resume(unfinished, maxAttempts)
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 
-	log.Printf("Processor API running on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	server := &http.Server{Addr: ":" + port}
+	go func() {
+		log.Printf("Processor API running on port %s", port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// On SIGTERM, stop taking events and give queued and running jobs time to finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	shutdownTimeout := 30 * time.Second
+	if v := os.Getenv("SHUTDOWN_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid SHUTDOWN_TIMEOUT %q: %v", v, err)
+		}
+		shutdownTimeout = d
+	}
+	log.Printf("Shutting down, waiting up to %s for jobs to finish", shutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server shutdown failed: %v", err)
+	}
+	if err := jobs.shutdown(shutdownCtx); err != nil {
+		log.Printf("Job workers did not finish before shutdown: %v", err)
+	}
+	log.Printf("Processor API stopped")
 }
 
-// handleProcess handles Event Grid calls including validation handshake
+// handleProcess handles Event Grid calls including validation handshake. Blob
+// events are queued as jobs and acknowledged before they are processed.
 func handleProcess(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -111,7 +305,8 @@ func handleProcess(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Handle events
+	// Validate every event before queueing any, so a bad batch queues nothing
+	var queued []job
 	for _, event := range events {
 		if event.EventType == "Microsoft.EventGrid.SubscriptionValidationEvent" {
 			// This should already be handled above, but just in case
@@ -127,141 +322,440 @@ func handleProcess(w http.ResponseWriter, r *http.Request) {
 		blobURL := event.Data.URL
 		log.Printf("New blob uploaded: %s", blobURL)
 
-		if err := processExcelBlob(blobURL); err != nil {
-			log.Printf("Failed to process blob: %v", err)
-			http.Error(w, "failed to process blob: "+err.Error(), http.StatusInternalServerError)
+		// Redelivering an unusable event cannot help, so it is acknowledged and skipped
+		if _, err := url.ParseRequestURI(blobURL); err != nil {
+			log.Printf("Skipping event %s: invalid blob URL %q: %v", event.ID, blobURL, err)
+			continue
+		}
+		if _, err := manifestFormat(blobURL); err != nil {
+			log.Printf("Skipping event %s: %v", event.ID, err)
+			continue
+		}
+
+		// Event Grid delivers at least once; duplicates are acknowledged without processing
+		if processedEvents.handledBlob(blobURL, event.Data.ETag) {
+			log.Printf("Skipping event %s: %s (ETag %s) was already processed", event.ID, blobURL, event.Data.ETag)
+			continue
+		}
+		if !processedEvents.claimEvent(event.ID) {
+			log.Printf("Skipping event %s: duplicate delivery", event.ID)
+			continue
+		}
+
+		j, err := newJob(event.ID, blobURL)
+		if err != nil {
+			processedEvents.releaseEvent(event.ID)
+			releaseJobs(queued)
+			log.Printf("Failed to create job: %v", err)
+			http.Error(w, "failed to queue blob: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		queued = append(queued, j)
 	}
 
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprint(w, "✅ Event processed")
+	for i, j := range queued {
+		if err := jobHistory.add(j); err != nil {
+			releaseJobs(queued[i:])
+			log.Printf("Failed to record job for %s: %v", j.BlobURL, err)
+			http.Error(w, "failed to queue blob: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := jobs.enqueue(j); err != nil {
+			// Event Grid retries on 503, picking the blob up once the queue has room
+			jobHistory.remove(j.ID)
+			releaseJobs(queued[i:])
+			log.Printf("Failed to queue job for %s: %v", j.BlobURL, err)
+			http.Error(w, "failed to queue blob: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "✅ Event accepted, %d job(s) queued", len(queued))
 }
 
-// processExcelBlob downloads the blob, processes it, and uploads to output container in different storage account
-func processExcelBlob(blobURL string) error {
-	ctx := context.Background()
-	cred, err := azidentity.NewManagedIdentityCredential(nil)
-	if err != nil {
-		return fmt.Errorf("failed to get MI credential: %w", err)
+// releaseJobs releases the event claims of jobs that were not queued
+func releaseJobs(unqueued []job) {
+	for _, j := range unqueued {
+		processedEvents.releaseEvent(j.EventID)
 	}
+}
 
-	// Create block blob client for input blob
-	blockBlobClient, err := blockblob.NewClient(blobURL, cred, nil)
+// processExcelBlob downloads the blob, processes it, and uploads to output container in different storage account.
+// It returns a nil report when this version of the blob was already processed.
+func processExcelBlob(ctx context.Context, blobURL string) (*processReport, error) {
+	input, err := downloadBlob(ctx, blobURL)
 	if err != nil {
-		return fmt.Errorf("failed to create block blob client: %w", err)
+		return nil, err
 	}
 
-	// Download blob
-	resp, err := blockBlobClient.DownloadStream(ctx, nil)
-	if err != nil {
-		return fmt.Errorf("failed to download blob: %w", err)
+	// A blob version already processed under another event is not processed again
+	if processedEvents.handledBlob(blobURL, input.ETag) {
+		log.Printf("Skipping %s: ETag %s was already processed", blobURL, input.ETag)
+		return nil, nil
 	}
-	defer resp.Body.Close()
+	data, metadata := input.Data, input.Metadata
 
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read blob: %w", err)
+	// Dry runs can be requested for the whole deployment or per upload
+	var opts processOptions
+	if v := os.Getenv("DRY_RUN"); v != "" {
+		opts.DryRun, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DRY_RUN %q: %w", v, err)
+		}
+	}
+	if v := metadataValue(metadata, dryRunMetadataKey); v != "" {
+		uploadDryRun, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s metadata %q: %w", dryRunMetadataKey, v, err)
+		}
+		opts.DryRun = opts.DryRun || uploadDryRun
+	}
+	if opts.DryRun {
+		log.Printf("Dry run: no tiers will be changed for %s", blobURL)
+	}
+
+	if opts.Workers, err = envInt("MAX_CONCURRENCY", 8); err != nil {
+		return nil, err
+	}
+	if opts.WorkersPerAccount, err = envInt("MAX_CONCURRENCY_PER_ACCOUNT", 4); err != nil {
+		return nil, err
+	}
+	opts.Sheets = splitList(os.Getenv("PROCESS_SHEETS"))
+	opts.Columns = columnMappings.forUploader(metadataUploader(metadata))
+	if len(opts.Columns.Sheets) > 0 {
+		opts.Sheets = opts.Columns.Sheets
+	}
+	if opts.Retry, err = loadRetryPolicy(); err != nil {
+		return nil, err
+	}
+	if opts.MaxPrefixBlobs, err = envInt("MAX_PREFIX_BLOBS", 10000); err != nil {
+		return nil, err
+	}
+	if opts.BatchSize, err = envInt("SET_TIER_BATCH_SIZE", maxBatchSize); err != nil {
+		return nil, err
+	}
+	if opts.BatchSize > maxBatchSize {
+		return nil, fmt.Errorf("invalid SET_TIER_BATCH_SIZE %d: a batch holds at most %d requests", opts.BatchSize, maxBatchSize)
 	}
 
-	// Open Excel directly from memory
-	f, err := excelize.OpenReader(bytes.NewReader(data))
+	// Open the manifest (Excel, CSV or JSON) directly from memory
+	m, err := loadManifest(blobURL, data)
 	if err != nil {
-		return fmt.Errorf("failed to open excel file: %w", err)
+		return nil, err
+	}
+	defer m.File.Close()
+	opts.Workbook = m.Format == formatXLSX
+	if m.Format == formatJSON {
+		opts.Columns.HeaderRow = 1 // JSON keys are always the first row
 	}
-	defer f.Close()
 
 	// Get output storage account and container from environment variables
 	outputStorageAccount := os.Getenv("OUTPUT_STORAGE_ACCOUNT")
 	if outputStorageAccount == "" {
-		return fmt.Errorf("OUTPUT_STORAGE_ACCOUNT environment variable not set")
+		return nil, fmt.Errorf("OUTPUT_STORAGE_ACCOUNT environment variable not set")
 	}
 
 	outputContainer := os.Getenv("OUTPUT_STORAGE_CONTAINER")
 	if outputContainer == "" {
-		return fmt.Errorf("OUTPUT_STORAGE_CONTAINER environment variable not set")
+		return nil, fmt.Errorf("OUTPUT_STORAGE_CONTAINER environment variable not set")
+	}
+
+	outputFile, err := processedFileName(blobURL, opts.DryRun)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract filename from URL: %w", err)
 	}
 
 	// Process the Excel file
-	statusUpdates, err := processExcelFile(f)
+	startedAt := time.Now().UTC()
+	report, err := processExcelFile(ctx, m.File, opts)
 	if err != nil {
-		return fmt.Errorf("failed to process excel file: %w", err)
+		return nil, fmt.Errorf("failed to process excel file: %w", err)
+	}
+	report.OutputFile = outputFile
+	report.StartedAt, report.FinishedAt = startedAt, time.Now().UTC()
+
+	// Workbooks get a Summary sheet for the uploader; CSV and JSON have nowhere to put it
+	if m.Format == formatXLSX {
+		inputFile, _ := extractFilenameFromURL(blobURL)
+		if err := addSummarySheet(m.File, report, inputFile); err != nil {
+			return nil, fmt.Errorf("failed to add summary sheet: %w", err)
+		}
 	}
 
-	// Save the modified Excel file to memory
-	var excelBuffer bytes.Buffer
-	if err := f.Write(&excelBuffer); err != nil {
-		return fmt.Errorf("failed to write excel to buffer: %w", err)
+	// Save the modified manifest to memory in its original format
+	outputBuffer, err := m.encode()
+	if err != nil {
+		return nil, err
 	}
 
+	// The output and its tracker are replaced together under the output file's lock,
+	// so the rehydration checker never republishes an earlier run's rows over them
+	unlock := outputLocks.lock(outputFile)
+	defer unlock()
+
 	// Upload processed file to output storage account
-	if err := uploadToOutputContainer(ctx, cred, outputStorageAccount, outputContainer, blobURL, &excelBuffer); err != nil {
-		return fmt.Errorf("failed to upload to output container: %w", err)
+	if err := uploadToOutputContainer(ctx, outputStorageAccount, outputContainer, outputFile, m.contentType(), outputBuffer); err != nil {
+		return nil, fmt.Errorf("failed to upload to output container: %w", err)
 	}
 
-	log.Printf("✅ Processing completed. Status updates: %+v", statusUpdates)
-	return nil
+	// Remember in-flight rehydrations so the checker can report their completion. An
+	// earlier run's tracker belongs to the output just replaced and is dropped.
+	if len(report.Rehydrations) == 0 {
+		if err := removeRehydrationTracker(outputFile); err != nil {
+			return nil, fmt.Errorf("failed to drop earlier rehydrations: %w", err)
+		}
+	} else {
+		tracker := &rehydrationTracker{
+			InputURL:        blobURL,
+			OutputAccount:   outputStorageAccount,
+			OutputContainer: outputContainer,
+			OutputFile:      outputFile,
+			Rehydrations:    report.Rehydrations,
+		}
+		if err := saveRehydrationTracker(tracker); err != nil {
+			return nil, fmt.Errorf("failed to record rehydrations: %w", err)
+		}
+		log.Printf("Tracking %d rehydrations for %s", len(report.Rehydrations), outputFile)
+	}
+
+	if err := processedEvents.recordBlob(blobURL, input.ETag); err != nil {
+		log.Printf("Failed to record %s as processed: %v", blobURL, err)
+	}
+
+	log.Printf("✅ Processing completed. Status updates: %+v", report.Stats)
+	return report, nil
 }
 
-// processExcelFile processes the Excel file and adds status column
-func processExcelFile(f *excelize.File) (map[string]int, error) {
-	stats := map[string]int{
-		"processed": 0,
-		"changed":   0,
-		"skipped":   0,
-		"errors":    0,
+// processExcelFile processes every selected worksheet of the Excel file, adding the
+// result columns to each. The report includes per-sheet stats and the rehydrations
+// that were submitted or found pending, keyed to their result rows. Once ctx is done
+// the remaining rows are abandoned and ctx's error is returned.
+func processExcelFile(ctx context.Context, f *excelize.File, opts processOptions) (*processReport, error) {
+	// A dry run reports the changes it would have made under their own counter
+	changedKey := "changed"
+	if opts.DryRun {
+		changedKey = "wouldChange"
 	}
 
-	// More flexible regex to match Azure blob URLs
-	regex := regexp.MustCompile(`https://([a-zA-Z0-9-]+)\.blob\.core\.windows\.net/([^/\s]+)/([^\s"]+)`)
+	report := &processReport{DryRun: opts.DryRun, Stats: newStats(changedKey), Currency: prices.Currency}
 
-	// Get all sheet names
+	// CSV and JSON manifests load into a single sheet, which is always processed;
+	// sheet selection only applies to workbooks
 	sheetList := f.GetSheetList()
-	if len(sheetList) == 0 {
-		return stats, fmt.Errorf("no sheets found in Excel file")
+	var err error
+	if opts.Workbook {
+		if sheetList, err = selectSheets(f, opts.Sheets); err != nil {
+			return report, err
+		}
+	}
+
+	defaultTier, err := parseAccessTier(workbookSetting(f, "TargetTier"))
+	if err != nil {
+		return report, fmt.Errorf("invalid workbook TargetTier: %w", err)
+	}
+	if defaultTier != "" {
+		log.Printf("Workbook default target tier: %s", defaultTier)
+	}
+
+	defaultPriority, err := parseRehydratePriority(os.Getenv("DEFAULT_REHYDRATE_PRIORITY"))
+	if err != nil {
+		return report, fmt.Errorf("invalid DEFAULT_REHYDRATE_PRIORITY: %w", err)
+	}
+	if defaultPriority == "" {
+		defaultPriority = blob.RehydratePriorityStandard
+	}
+
+	// Queue the rows of every sheet first so one worker pool serves the whole workbook
+	var plans []*sheetPlan
+	var tasks []rowTask
+	for _, sheetName := range sheetList {
+		plan, err := planSheet(ctx, f, sheetName, defaultTier, defaultPriority, opts)
+		if err != nil {
+			return report, err
+		}
+		if plan == nil {
+			continue
+		}
+		plans = append(plans, plan)
+		tasks = append(tasks, plan.allTasks()...)
 	}
 
-	// Use the first sheet (dynamic sheet name)
-	sheetName := sheetList[0]
+	// Process the blobs concurrently; outcomes come back in row order
+	log.Printf("Processing %d rows from %d sheets with %d workers (%d per storage account)",
+		len(tasks), len(plans), opts.Workers, opts.WorkersPerAccount)
+	outcomes := runRowTasks(ctx, tasks, opts.Workers, opts.WorkersPerAccount, opts.BatchSize, opts.Retry)
+	if err := ctx.Err(); err != nil {
+		return report, fmt.Errorf("processing stopped: %w", err)
+	}
+
+	// Blobs matched by prefix rows get rows of their own on one extra worksheet
+	var expansion *expansionSheet
+	if opts.Workbook && slices.ContainsFunc(plans, func(p *sheetPlan) bool { return len(p.Prefixes) > 0 }) {
+		if expansion, err = newExpansionSheet(f); err != nil {
+			return report, fmt.Errorf("failed to add expansion sheet: %w", err)
+		}
+	}
+
+	for _, plan := range plans {
+		planTasks := len(plan.allTasks())
+		sheet, rehydrations := writeSheetResults(f, plan, outcomes[:planTasks], expansion, changedKey, opts)
+		outcomes = outcomes[planTasks:]
+		sheetStats := sheet.Stats
+
+		if opts.Workbook {
+			if err := styleSheet(f, plan); err != nil {
+				log.Printf("Failed to style sheet %s: %v", plan.Name, err)
+			}
+		}
+
+		log.Printf("Processing completed for sheet '%s'. Stats: %+v", plan.Name, sheetStats)
+		report.Sheets = append(report.Sheets, sheet)
+		report.Cost.add(&sheet.Cost)
+		report.Rehydrations = append(report.Rehydrations, rehydrations...)
+		for key, count := range sheetStats {
+			report.Stats[key] += count
+		}
+	}
+	if expansion != nil {
+		if err := expansion.style(); err != nil {
+			log.Printf("Failed to style sheet %s: %v", expansion.Name, err)
+		}
+	}
+
+	return report, nil
+}
+
+// sheetPlan is a worksheet whose rows have been queued for processing
+type sheetPlan struct {
+	Name string
+	// HeaderRow is the zero-based row holding the headers
+	HeaderRow int
+	// URLColIndex is the column holding blob URLs, and ResultColIndex the first of the
+	// resultHeaders columns
+	URLColIndex    int
+	ResultColIndex int
+	// Rows is the number of sheet rows down to the table's last row
+	Rows  int
+	Tasks []rowTask
+	// Prefixes are the rows naming a container, virtual directory or name prefix
+	Prefixes []*prefixRow
+	// Invalid are the rows whose location could not be parsed
+	Invalid []invalidRow
+}
+
+// invalidRow is a manifest row whose location is not a blob URL; no blob is touched
+type invalidRow struct {
+	RowIndex int
+	// Value is the manifest cell
+	Value string
+	Err   error
+}
+
+// allTasks returns the sheet's row tasks followed by the tasks of each prefix row, the
+// order their outcomes are expected in
+func (p *sheetPlan) allTasks() []rowTask {
+	tasks := slices.Clone(p.Tasks)
+	for _, prefix := range p.Prefixes {
+		tasks = append(tasks, prefix.Tasks...)
+	}
+	return tasks
+}
+
+// planSheet finds the URL column of a worksheet, adds its result headers and queues
+// its rows. It returns nil when the sheet has no URL column.
+func planSheet(ctx context.Context, f *excelize.File, sheetName string, defaultTier blob.AccessTier, defaultPriority blob.RehydratePriority, opts processOptions) (*sheetPlan, error) {
 	log.Printf("Processing sheet: %s", sheetName)
 
 	// Get all rows from the sheet
 	rows, err := f.GetRows(sheetName)
 	if err != nil {
-		return stats, fmt.Errorf("failed to get rows from sheet %s: %w", sheetName, err)
+		return nil, fmt.Errorf("failed to get rows from sheet %s: %w", sheetName, err)
 	}
 
 	if len(rows) == 0 {
 		log.Printf("No rows found in sheet: %s", sheetName)
-		return stats, nil
+		return nil, nil
 	}
 
 	log.Printf("Found %d rows in sheet: %s", len(rows), sheetName)
 
-	// Find the column that contains blob URLs by checking header names and content
-	urlColIndex := findURLColumn(rows, regex)
+	// A header row set by the column mapping must exist
+	if opts.Columns.HeaderRow > len(rows) {
+		log.Printf("Header row %d not found in sheet: %s", opts.Columns.HeaderRow, sheetName)
+		return nil, nil
+	}
+
+	// Find the manifest table, an Excel table or the rows below the header row, with
+	// the column that contains blob URLs: from the mapping, or else by checking header
+	// names and content
+	var region sheetRegion
+	urlColIndex := -1
+	for _, candidate := range manifestRegions(f, sheetName, rows, opts.Columns.HeaderRow) {
+		view := candidate.view(rows)
+		index, mapped := mappedColumn(view[candidate.HeaderRow], opts.Columns.URL)
+		if !mapped {
+			index = findURLColumn(view[candidate.HeaderRow:])
+		}
+		if index != -1 {
+			region, urlColIndex = candidate, index
+			break
+		}
+	}
 	if urlColIndex == -1 {
 		log.Printf("No URL column found in sheet: %s", sheetName)
-		return stats, nil
+		return nil, nil
 	}
 
-	log.Printf("Found URL column at index: %d (header: '%s')", urlColIndex, rows[0][urlColIndex])
-
-	// Status column will be added after the last column
-	statusColIndex := len(rows[0])
+	if region.Table != "" {
+		log.Printf("Using table %s in rows %d-%d of sheet: %s", region.Table, region.HeaderRow+1, region.LastRow+1, sheetName)
+	} else if region.HeaderRow > 0 {
+		log.Printf("Found header row %d in sheet: %s", region.HeaderRow+1, sheetName)
+	}
+	resultColIndex := region.resultCol(rows)
+	rows = region.view(rows)
+	headers := rows[region.HeaderRow]
+
+	log.Printf("Found URL column at index: %d (header: '%s')", urlColIndex, cellValue(headers, urlColIndex))
+
+	// Optional per-row target tier (falling back to the workbook-level default),
+	// rehydrate priority (falling back to the deployment default) and copy destination.
+	// A column the mapping names must exist.
+	var columns manifestColumns
+	for _, c := range []struct {
+		index   *int
+		name    string
+		ref     string
+		headers []string
+	}{
+		{&columns.Tier, "target tier", opts.Columns.Tier, targetTierHeaders},
+		{&columns.Priority, "rehydrate priority", opts.Columns.Priority, rehydratePriorityHeaders},
+		{&columns.Destination, "destination", opts.Columns.Destination, destinationHeaders},
+	} {
+		index, mapped := mappedColumn(headers, c.ref)
+		if !mapped {
+			index = findHeaderColumn(headers, c.headers)
+		} else if index == -1 {
+			return nil, fmt.Errorf("sheet %s has no %s column %q", sheetName, c.name, c.ref)
+		}
+		if index != -1 {
+			log.Printf("Found %s column at index: %d (header: '%s')", c.name, index, cellValue(headers, index))
+		}
+		*c.index = index
+	}
 
-	// Add "Status" header to the first row
-	statusCell, err := excelize.CoordinatesToCellName(statusColIndex+1, 1)
-	if err != nil {
-		return stats, fmt.Errorf("failed to get status cell coordinates: %w", err)
+	// Result columns will be added after the table's last column
+	for _, index := range []int{urlColIndex, columns.Tier, columns.Priority, columns.Destination} {
+		resultColIndex = max(resultColIndex, index+1)
 	}
+	plan := &sheetPlan{Name: sheetName, HeaderRow: region.HeaderRow, URLColIndex: urlColIndex, ResultColIndex: resultColIndex, Rows: len(rows)}
 
-	if err := f.SetCellValue(sheetName, statusCell, "Status"); err != nil {
-		return stats, fmt.Errorf("failed to set status header: %w", err)
+	if err := writeResultHeaders(f, sheetName, plan.HeaderRow, plan.ResultColIndex); err != nil {
+		return nil, fmt.Errorf("failed to set result headers: %w", err)
 	}
 
-	// Process each row starting from row 2 (skip header)
-	for rowIndex := 1; rowIndex < len(rows); rowIndex++ {
+	// Collect each row of the table below the header
+	for rowIndex := region.HeaderRow + 1; rowIndex < len(rows); rowIndex++ {
 		row := rows[rowIndex]
 		if len(row) == 0 {
 			continue // Skip empty rows
@@ -269,84 +763,297 @@ func processExcelFile(f *excelize.File) (map[string]int, error) {
 
 		// Ensure the row has enough columns
 		if urlColIndex >= len(row) {
-			log.Printf("Row %d: URL column index out of bounds (row has %d columns, need %d)", 
-				rowIndex+1, len(row), urlColIndex+1)
+			log.Printf("%s row %d: URL column index out of bounds (row has %d columns, need %d)",
+				sheetName, rowIndex+1, len(row), urlColIndex+1)
 			continue
 		}
 
 		urlValue := strings.TrimSpace(row[urlColIndex])
 		if urlValue == "" {
-			log.Printf("Row %d: Empty URL value", rowIndex+1)
+			log.Printf("%s row %d: Empty URL value", sheetName, rowIndex+1)
 			continue
 		}
 
-		m := regex.FindStringSubmatch(urlValue)
-		if m == nil {
-			log.Printf("Row %d: URL doesn't match expected format: %s", rowIndex+1, urlValue)
+		// A container, virtual directory or name prefix applies the row to every match
+		if prefix, ok, err := parseBlobPrefix(urlValue); ok {
+			p := &prefixRow{RowIndex: rowIndex, Value: urlValue, Prefix: prefix, Err: err}
+			if err == nil {
+				p.Tasks, p.Err = expandPrefixRow(ctx, row, columns, prefix, defaultTier, defaultPriority, opts)
+			}
+			if p.Err != nil {
+				log.Printf("%s row %d: Failed to expand %s: %v", sheetName, rowIndex+1, urlValue, p.Err)
+			} else {
+				log.Printf("%s row %d: Queueing %d blobs matching %s", sheetName, rowIndex+1, len(p.Tasks), prefix)
+			}
+			plan.Prefixes = append(plan.Prefixes, p)
 			continue
 		}
 
-		stats["processed"]++
-		account := m[1]
-		containerName := m[2]
-		blobPath := m[3]
+		source, err := parseBlobRef(urlValue)
+		if err != nil {
+			log.Printf("%s row %d: URL doesn't match expected format: %v", sheetName, rowIndex+1, err)
+			plan.Invalid = append(plan.Invalid, invalidRow{RowIndex: rowIndex, Value: urlValue, Err: err})
+			continue
+		}
 
-		log.Printf("Row %d: Processing blob - account=%s, container=%s, path=%s", 
-			rowIndex+1, account, containerName, blobPath)
+		log.Printf("%s row %d: Queueing blob - account=%s, container=%s, path=%s",
+			sheetName, rowIndex+1, source.Account, source.Container, source.Path)
 
-		// Process the blob and get status
-		status, err := processBlobTier(account, containerName, blobPath)
-		if err != nil {
-			stats["errors"]++
-			status = fmt.Sprintf("Error: %v", err)
-			log.Printf("Row %d: Error processing blob: %v", rowIndex+1, err)
-		} else {
-			if strings.Contains(status, "Changed: Archive → Cool") {
-				stats["changed"]++
-			} else {
-				stats["skipped"]++
-			}
-			log.Printf("Row %d: %s", rowIndex+1, status)
+		req, err := rowTierRequest(row, columns, source, defaultTier, defaultPriority)
+		req.DryRun = opts.DryRun
+		plan.Tasks = append(plan.Tasks, rowTask{RowIndex: rowIndex, Source: source, Req: req, Err: err})
+	}
+
+	return plan, nil
+}
+
+// writeSheetResults writes the outcome of each queued row to the sheet's result columns
+// and annotates the Result and cost total headers with the sheet's stats and estimated
+// cost. The blobs of prefix rows are written to expansion when it is not nil, and
+// totalled on their originating row.
+func writeSheetResults(f *excelize.File, plan *sheetPlan, outcomes []rowOutcome, expansion *expansionSheet, changedKey string, opts processOptions) (sheetReport, []rehydrationRecord) {
+	stats := newStats(changedKey)
+	var cost costEstimate
+	var rows []rowSummary
+	var rehydrations []rehydrationRecord
+	sheetName := plan.Name
+
+	for i, task := range plan.Tasks {
+		rowIndex := task.RowIndex
+		rr, outcome := tallyOutcome(stats, &cost, changedKey, task, outcomes[i], fmt.Sprintf("%s row %d", sheetName, rowIndex+1))
+		rows = append(rows, newRowSummary(sheetName, rowIndex, plan.ResultColIndex, task.Source, rr, outcome))
+
+		if outcomes[i].Result.Pending && !opts.DryRun {
+			rehydrations = append(rehydrations, newRehydrationRecord(task, outcomes[i].Result, rr, sheetName, rowIndex, plan.ResultColIndex))
 		}
 
-		// Write status to the Status column
-		statusCell, err := excelize.CoordinatesToCellName(statusColIndex+1, rowIndex+1)
-		if err != nil {
+		if err := writeRowResult(f, sheetName, rowIndex, plan.ResultColIndex, rr); err != nil {
 			stats["errors"]++
-			log.Printf("Row %d: Failed to get status cell coordinates: %v", rowIndex+1, err)
+			log.Printf("%s row %d: Failed to write result: %v", sheetName, rowIndex+1, err)
 			continue
 		}
+	}
 
-		if err := f.SetCellValue(sheetName, statusCell, status); err != nil {
-			stats["errors"]++
-			log.Printf("Row %d: Failed to set status cell value: %v", rowIndex+1, err)
-			continue
+	for _, r := range plan.Invalid {
+		stats["processed"]++
+		stats["errors"]++
+		rr := rowResult{Code: resultInvalidRow, Timestamp: time.Now().UTC(), Error: r.Err.Error()}
+		rows = append(rows, rowSummary{Sheet: sheetName, Row: r.RowIndex, ResultCol: plan.ResultColIndex, Value: r.Value, Outcome: "errors", Code: rr.Code, Error: rr.Error})
+
+		if err := writeRowResult(f, sheetName, r.RowIndex, plan.ResultColIndex, rr); err != nil {
+			log.Printf("%s row %d: Failed to write result: %v", sheetName, r.RowIndex+1, err)
+		}
+	}
+
+	sheet := sheetReport{Name: sheetName, Stats: stats, Rows: rows}
+	outcomes = outcomes[len(plan.Tasks):]
+	for _, p := range plan.Prefixes {
+		rehydrations = append(rehydrations, writePrefixResults(f, plan, p, outcomes[:len(p.Tasks)], expansion, changedKey, opts, &sheet, &cost)...)
+		outcomes = outcomes[len(p.Tasks):]
+	}
+	sheet.Cost = cost
+
+	// Per-sheet stats travel with the output as a note on the Result header
+	headerCell, err := excelize.CoordinatesToCellName(plan.ResultColIndex+1, plan.HeaderRow+1)
+	if err == nil {
+		err = f.AddComment(sheetName, excelize.Comment{Cell: headerCell, Author: "autotier", Text: formatStats(stats)})
+	}
+	if err != nil {
+		log.Printf("Failed to add stats note to sheet %s: %v", sheetName, err)
+	}
+	addCostNote(f, sheetName, plan.HeaderRow, plan.ResultColIndex, cost)
+
+	return sheet, rehydrations
+}
+
+// tallyOutcome counts the outcome of a task in stats and its estimated cost in cost,
+// logs it under label and returns the task's result columns along with the stats key
+// it was counted under: changedKey, "pending", "skipped" or "errors"
+func tallyOutcome(stats map[string]int, cost *costEstimate, changedKey string, task rowTask, outcome rowOutcome, label string) (rowResult, string) {
+	stats["processed"]++
+	result, err := outcome.Result, outcome.Err
+	if outcome.Attempts > 1 {
+		stats["retries"] += outcome.Attempts - 1
+	}
+
+	rr := rowResult{
+		Code:          result.Code,
+		PreviousTier:  string(result.FromTier),
+		NewTier:       string(result.ToTier),
+		ArchiveStatus: result.ArchiveStatus,
+		Priority:      string(result.Priority),
+		Timestamp:     time.Now().UTC(),
+		Attempts:      outcome.Attempts,
+		Details:       result.Status,
+		ResolvedURL:   task.Source.URL(),
+		Version:       result.Version,
+		Cost:          result.Cost,
+	}
+	var key string
+	switch {
+	case task.Err != nil:
+		key = "errors"
+		rr.Code, rr.Error, rr.Details, rr.Cost = resultInvalidRow, err.Error(), "", nil
+		log.Printf("%s: Invalid row: %v", label, err)
+	case err != nil:
+		key = "errors"
+		code, message := classifyError(err)
+		if action := strings.TrimPrefix(result.Status, "Error: "); action != "" {
+			message = fmt.Sprintf("%s: %s", action, message)
+		}
+		rr.Code, rr.NewTier, rr.Priority, rr.Error, rr.Details, rr.Cost = code, "", "", message, "", nil
+		log.Printf("%s: Error processing blob: %v", label, err)
+	default:
+		switch {
+		case result.Changed:
+			key = changedKey
+			stats[fmt.Sprintf("%s → %s", result.FromTier, result.ToTier)]++
+		case result.Pending:
+			key = "pending"
+		default:
+			key = "skipped"
 		}
+		log.Printf("%s: %s", label, result.Status)
+	}
+	stats[key]++
+	cost.add(rr.Cost)
+	return rr, key
+}
+
+// newRehydrationRecord returns the record tracking a pending task, whose result
+// columns start at the zero-based row and column of sheetName
+func newRehydrationRecord(task rowTask, result tierResult, rr rowResult, sheetName string, row, resultCol int) rehydrationRecord {
+	return rehydrationRecord{
+		Account:     task.Source.Account,
+		Container:   task.Source.Container,
+		BlobPath:    task.Source.Path,
+		Endpoint:    task.Source.Endpoint,
+		VersionID:   task.Source.VersionID,
+		Snapshot:    task.Source.Snapshot,
+		Version:     result.Version,
+		Sheet:       sheetName,
+		Row:         row,
+		ResultCol:   resultCol,
+		FromTier:    string(result.FromTier),
+		ToTier:      string(result.ToTier),
+		Priority:    string(result.Priority),
+		SubmittedAt: rr.Timestamp,
+		Attempts:    rr.Attempts,
+		Cost:        rr.Cost,
+		Destination: result.Destination,
+		CopyID:      result.CopyID,
 	}
+}
 
-	log.Printf("Processing completed for sheet '%s'. Stats: %+v", sheetName, stats)
-	return stats, nil
+// newStats returns a stats map with the standard counters set to zero
+func newStats(changedKey string) map[string]int {
+	return map[string]int{
+		"processed": 0,
+		changedKey:  0,
+		"pending":   0,
+		"skipped":   0,
+		"errors":    0,
+	}
 }
 
-// findURLColumn searches for the column that contains Azure blob URLs
-func findURLColumn(rows [][]string, regex *regexp.Regexp) int {
+// formatStats renders stats as a single line in statsKeys order
+func formatStats(stats map[string]int) string {
+	var parts []string
+	for _, key := range statsKeys(stats) {
+		parts = append(parts, fmt.Sprintf("%s: %d", key, stats[key]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// statsKeys returns the keys of stats, standard counters first and tier transitions
+// after them in name order
+func statsKeys(stats map[string]int) []string {
+	order := []string{"processed", "changed", "wouldChange", "pending", "skipped", "errors", "retries"}
+	var keys []string
+	for _, key := range order {
+		if _, ok := stats[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+
+	var extra []string
+	for key := range stats {
+		if !slices.Contains(order, key) {
+			extra = append(extra, key)
+		}
+	}
+	sort.Strings(extra)
+	return append(keys, extra...)
+}
+
+// selectSheets returns the worksheets to process: those named by the workbook's
+// Sheets setting or the configured list, or every sheet when neither is set
+func selectSheets(f *excelize.File, configured []string) ([]string, error) {
+	sheetList := f.GetSheetList()
+	if len(sheetList) == 0 {
+		return nil, fmt.Errorf("no sheets found in Excel file")
+	}
+
+	wanted := configured
+	if workbookSheets := splitList(workbookSetting(f, "Sheets")); len(workbookSheets) > 0 {
+		wanted = workbookSheets
+	}
+	if len(wanted) == 0 {
+		return sheetList, nil
+	}
+
+	var selected []string
+	for _, name := range wanted {
+		found := false
+		for _, sheetName := range sheetList {
+			if strings.EqualFold(name, sheetName) {
+				selected = append(selected, sheetName)
+				found = true
+				break
+			}
+		}
+		if !found {
+			log.Printf("Configured sheet %q not found in workbook", name)
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("none of the configured sheets %v exist in the workbook", wanted)
+	}
+	return selected, nil
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// urlHeaders are common column names that might contain blob URLs
+var urlHeaders = []string{
+	"azure_blob_location", "blob_location", "azure_blob", "blob_url",
+	"url", "blob", "location", "file_path", "file_url", "storage_url",
+	"azure_storage_url", "blob_path", "adls_path", "abfss_path",
+}
+
+// findURLColumn searches for the column that contains Azure blob URLs. rows[0] is the
+// header row.
+func findURLColumn(rows [][]string) int {
 	if len(rows) == 0 {
 		return -1
 	}
 
 	headers := rows[0]
 	
-	// Common column names that might contain blob URLs
-	commonURLHeaders := []string{
-		"azure_blob_location", "blob_location", "azure_blob", "blob_url", 
-		"url", "blob", "location", "file_path", "file_url", "storage_url",
-		"azure_storage_url", "blob_path",
-	}
-
 	// First, try to find by header name (case insensitive)
 	for colIndex, header := range headers {
 		cleanHeader := strings.ToLower(strings.TrimSpace(header))
-		for _, commonHeader := range commonURLHeaders {
+		for _, commonHeader := range urlHeaders {
 			if cleanHeader == commonHeader {
 				log.Printf("Found URL column by header name: '%s' at index %d", header, colIndex)
 				return colIndex
@@ -366,7 +1073,7 @@ func findURLColumn(rows [][]string, regex *regexp.Regexp) int {
 			if colIndex >= len(urlMatches) {
 				continue
 			}
-			if regex.MatchString(strings.TrimSpace(cellValue)) {
+			if _, err := parseBlobRef(cellValue); err == nil {
 				urlMatches[colIndex]++
 			}
 		}
@@ -391,102 +1098,289 @@ func findURLColumn(rows [][]string, regex *regexp.Regexp) int {
 	return -1
 }
 
-// processBlobTier checks and updates blob tier if necessary
-func processBlobTier(account, containerName, blobPath string) (string, error) {
-	ctx := context.Background()
-	cred, err := azidentity.NewManagedIdentityCredential(nil)
+// processBlobTier checks and updates blob tier if necessary. When no target tier
+// is requested only archived blobs are moved, and they are moved to Cool.
+func processBlobTier(ctx context.Context, source blobLocation, req tierRequest) (tierResult, error) {
+	props, err := storage.GetProperties(ctx, source)
 	if err != nil {
-		return "Error: Failed to get credential", err
+		return tierResult{Status: "Error: Blob not accessible"}, err
 	}
 
-	// Properly URL encode the blob path
-	pathSegments := strings.Split(blobPath, "/")
-	for i, segment := range pathSegments {
-		pathSegments[i] = url.PathEscape(segment)
+	result, err := changeBlobTier(ctx, source, props, req)
+	result.Version = versionLabel(source, props)
+	if err == nil {
+		result.Cost = prices.estimate(props, result, req.Priority, time.Now())
 	}
-	encodedBlobPath := strings.Join(pathSegments, "/")
+	return result, err
+}
 
-	serviceURL := fmt.Sprintf("https://%s.blob.core.windows.net/", account)
-	serviceClient, err := service.NewClient(serviceURL, cred, nil)
-	if err != nil {
-		return "Error: Failed to create service client", err
+// versionLabel describes what an operation on loc acted on: the version ID or snapshot
+// the location names, or the current version ID of a base blob in an account that
+// keeps versions. It is empty for base blobs without versioning.
+func versionLabel(loc blobLocation, props blobProperties) string {
+	switch {
+	case loc.VersionID != "":
+		return loc.VersionID
+	case loc.Snapshot != "":
+		return "snapshot " + loc.Snapshot
+	case props.VersionID != "":
+		return props.VersionID + " (current)"
+	}
+	return ""
+}
+
+// changeBlobTier moves the blob at source, whose properties are props, to the requested
+// tier, or copies it when the request has a destination
+func changeBlobTier(ctx context.Context, source blobLocation, props blobProperties, req tierRequest) (tierResult, error) {
+	if props.AccessTier == "" {
+		return tierResult{Code: resultSkipped, Status: "Skipped: No access tier set"}, nil
 	}
 
-	containerClient := serviceClient.NewContainerClient(containerName)
-	blobClient := containerClient.NewBlobClient(encodedBlobPath)
+	currentTier := props.AccessTier
+	log.Printf("Blob %s current tier: %s", source, currentTier)
 
-	props, err := blobClient.GetProperties(ctx, nil)
-	if err != nil {
-		return "Error: Blob not accessible", err
+	if req.Destination != nil {
+		return copyBlobTier(ctx, source, currentTier, req)
 	}
 
-	if props.AccessTier == nil {
-		return "Skipped: No access tier set", nil
+	// A blob that is already rehydrating cannot be re-tiered until rehydration finishes
+	if strings.HasPrefix(props.ArchiveStatus, archiveStatusPendingPrefix) {
+		archiveStatus := props.ArchiveStatus
+		pendingTier, _ := parseAccessTier(strings.TrimPrefix(archiveStatus, archiveStatusPendingPrefix))
+		priority := props.RehydratePriority
+		return tierResult{
+			Code:          resultPending,
+			Status:        fmt.Sprintf("Pending: Archive → %s (%s)", pendingTier, archiveStatus),
+			Pending:       true,
+			FromTier:      currentTier,
+			ToTier:        pendingTier,
+			Priority:      priority,
+			ArchiveStatus: archiveStatus,
+		}, nil
 	}
 
-	currentTier := blob.AccessTier(*props.AccessTier)
-	log.Printf("Blob %s/%s/%s current tier: %s", account, containerName, blobPath, currentTier)
+	targetTier := req.TargetTier
+	if targetTier == "" {
+		if currentTier != blob.AccessTierArchive {
+			return tierResult{Code: resultSkipped, Status: fmt.Sprintf("Skipped: Already %s", string(currentTier)), FromTier: currentTier, ToTier: currentTier}, nil
+		}
+		targetTier = blob.AccessTierCool
+	}
 
-	if currentTier == blob.AccessTierArchive {
-		blockClient, err := blockblob.NewClient(blobClient.URL(), cred, nil)
-		if err != nil {
-			return "Error: Failed to create block client", err
+	if currentTier == targetTier {
+		return tierResult{Code: resultSkipped, Status: fmt.Sprintf("Skipped: Already %s", string(currentTier)), FromTier: currentTier, ToTier: currentTier}, nil
+	}
+
+	if req.DryRun {
+		status := fmt.Sprintf("Would change: %s → %s", currentTier, targetTier)
+		if currentTier == blob.AccessTierArchive && req.Priority != "" {
+			status = fmt.Sprintf("%s (%s priority)", status, req.Priority)
 		}
+		return tierResult{Code: resultWouldChange, Status: status, Changed: true, FromTier: currentTier, ToTier: targetTier}, nil
+	}
 
-		_, err = blockClient.SetTier(ctx, blob.AccessTierCool, nil)
-		if err != nil {
-			return "Error: Failed to set tier", err
+	// Rehydrate priority only applies when moving a blob out of Archive, and the
+	// blob then stays pending until the rehydration completes
+	status := fmt.Sprintf("Changed: %s → %s", currentTier, targetTier)
+	var priority blob.RehydratePriority
+	rehydrating := currentTier == blob.AccessTierArchive && targetTier != blob.AccessTierArchive
+	if rehydrating {
+		if req.Priority != "" {
+			priority = req.Priority
+			status = fmt.Sprintf("%s (%s priority, rehydration pending)", status, priority)
+		} else {
+			status += " (rehydration pending)"
 		}
+	}
+
+	result := tierResult{
+		Code:     resultChanged,
+		Status:   status,
+		Changed:  true,
+		FromTier: currentTier,
+		ToTier:   targetTier,
+		Priority: priority,
+		Pending:  rehydrating,
+	}
+	if rehydrating {
+		result.ArchiveStatus = archiveStatusPendingPrefix + strings.ToLower(string(targetTier))
+	}
 
-		return "Changed: Archive → Cool", nil
+	if req.Batch {
+		result.SetTier = &tierChange{Location: source, Tier: targetTier, Priority: priority}
+		return result, nil
+	}
+	if err := storage.SetTier(ctx, source, targetTier, priority); err != nil {
+		return tierResult{Status: "Error: Failed to set tier", FromTier: currentTier}, err
 	}
+	return result, nil
+}
+
+// archiveStatusPendingPrefix prefixes the ArchiveStatus of a blob that is being rehydrated
+const archiveStatusPendingPrefix = "rehydrate-pending-to-"
 
-	return fmt.Sprintf("Skipped: Already %s", string(currentTier)), nil
+// metadataValue looks up a blob metadata value. Keys are matched case insensitively
+// because the service returns them as canonicalized HTTP header names.
+func metadataValue(metadata map[string]*string, key string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(k, key) && v != nil {
+			return strings.TrimSpace(*v)
+		}
+	}
+	return ""
 }
 
-// uploadToOutputContainer uploads the processed file to the output container in the specified storage account
-func uploadToOutputContainer(ctx context.Context, cred *azidentity.ManagedIdentityCredential, storageAccount, outputContainer, originalBlobURL string, excelBuffer *bytes.Buffer) error {
-	serviceURL := fmt.Sprintf("https://%s.blob.core.windows.net/", storageAccount)
-	serviceClient, err := service.NewClient(serviceURL, cred, nil)
+// targetTierHeaders are the header names recognised for the optional target tier column
+var targetTierHeaders = []string{"target_tier", "target tier", "targettier"}
+
+// rehydratePriorityHeaders are the header names recognised for the optional rehydrate priority column
+var rehydratePriorityHeaders = []string{"rehydrate_priority", "rehydrate priority", "rehydratepriority"}
+
+// manifestColumns holds the indexes of the optional per-row columns (-1 when absent)
+type manifestColumns struct {
+	Tier        int
+	Priority    int
+	Destination int
+}
+
+// rowTierRequest builds the tier request for a row from its optional tier, priority and destination columns
+func rowTierRequest(row []string, columns manifestColumns, source blobLocation, defaultTier blob.AccessTier, defaultPriority blob.RehydratePriority) (tierRequest, error) {
+	req := tierRequest{TargetTier: defaultTier, Priority: defaultPriority}
+
+	tier, err := parseAccessTier(cellValue(row, columns.Tier))
 	if err != nil {
-		return fmt.Errorf("failed to create service client for output storage account: %w", err)
+		return req, err
+	}
+	if tier != "" {
+		req.TargetTier = tier
 	}
 
-	// Ensure output container exists
-	containerClient := serviceClient.NewContainerClient(outputContainer)
-	_, err = containerClient.GetProperties(ctx, nil)
+	priority, err := parseRehydratePriority(cellValue(row, columns.Priority))
 	if err != nil {
-		// Container doesn't exist, try to create it
-		_, err = containerClient.Create(ctx, nil)
-		if err != nil {
-			return fmt.Errorf("failed to create output container: %w", err)
-		}
-		log.Printf("Created output container: %s in storage account: %s", outputContainer, storageAccount)
+		return req, err
+	}
+	if priority != "" {
+		req.Priority = priority
 	}
 
-	// Extract original filename from blob URL
-	originalFilename, err := extractFilenameFromURL(originalBlobURL)
+	req.Destination, err = parseDestination(cellValue(row, columns.Destination), source)
 	if err != nil {
-		return fmt.Errorf("failed to extract filename from URL: %w", err)
+		return req, err
 	}
 
-	// Create new filename with timestamp or processed marker
-	newFilename := strings.TrimSuffix(originalFilename, ".xlsx") + "_processed.xlsx"
+	return req, nil
+}
 
-	// Convert bytes.Buffer to ReadSeekCloser which implements io.ReadSeekCloser
-	excelData := excelBuffer.Bytes()
-	reader := ReadSeekCloser{bytes.NewReader(excelData)}
+// findHeaderColumn returns the index of the first header matching one of names (case insensitive), or -1
+func findHeaderColumn(headers []string, names []string) int {
+	for colIndex, header := range headers {
+		cleanHeader := strings.ToLower(strings.TrimSpace(header))
+		for _, name := range names {
+			if cleanHeader == name {
+				return colIndex
+			}
+		}
+	}
+	return -1
+}
 
-	// Upload to output container in the specified storage account
-	blobClient := containerClient.NewBlockBlobClient(newFilename)
-	contentType := "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
-	
-	_, err = blobClient.Upload(ctx, reader, &blockblob.UploadOptions{
-		HTTPHeaders: &blob.HTTPHeaders{
-			BlobContentType: &contentType,
-		},
-	})
-	if err != nil {
+// envInt reads a positive integer from the environment, returning def when it is unset
+func envInt(name string, def int) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid %s %q: expected a positive integer", name, v)
+	}
+	return n, nil
+}
+
+// envDuration reads a positive duration from the environment, returning def when it is unset
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: expected a positive duration", name, v)
+	}
+	return d, nil
+}
+
+// cellValue returns the trimmed value of row at colIndex, or "" when the column is absent
+func cellValue(row []string, colIndex int) string {
+	if colIndex < 0 || colIndex >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[colIndex])
+}
+
+// parseAccessTier parses a user supplied tier name (Hot/Cool/Cold/Archive, case insensitive).
+// An empty value returns an empty tier.
+func parseAccessTier(value string) (blob.AccessTier, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+	for _, tier := range []blob.AccessTier{blob.AccessTierHot, blob.AccessTierCool, blob.AccessTierCold, blob.AccessTierArchive} {
+		if strings.EqualFold(value, string(tier)) {
+			return tier, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported tier %q (expected Hot, Cool, Cold or Archive)", value)
+}
+
+// parseRehydratePriority parses a user supplied rehydrate priority (Standard/High, case insensitive).
+// An empty value returns an empty priority.
+func parseRehydratePriority(value string) (blob.RehydratePriority, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+	for _, priority := range blob.PossibleRehydratePriorityValues() {
+		if strings.EqualFold(value, string(priority)) {
+			return priority, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported rehydrate priority %q (expected Standard or High)", value)
+}
+
+// workbookSetting returns the value of a workbook-scoped defined name. The name may
+// hold a constant (e.g. ="Cool") or refer to a single cell (e.g. =Settings!$B$2).
+func workbookSetting(f *excelize.File, name string) string {
+	for _, dn := range f.GetDefinedName() {
+		if dn.Scope != "Workbook" || !strings.EqualFold(dn.Name, name) {
+			continue
+		}
+
+		refersTo := strings.TrimSpace(strings.TrimPrefix(dn.RefersTo, "="))
+		if strings.HasPrefix(refersTo, "\"") {
+			return strings.Trim(refersTo, "\"")
+		}
+
+		sheet, cell, found := strings.Cut(refersTo, "!")
+		if !found {
+			return refersTo
+		}
+		sheet = strings.Trim(sheet, "'")
+		cell = strings.ReplaceAll(cell, "$", "")
+		value, err := f.GetCellValue(sheet, cell)
+		if err != nil {
+			log.Printf("Failed to resolve workbook setting %s (%s): %v", name, dn.RefersTo, err)
+			return ""
+		}
+		return value
+	}
+	return ""
+}
+
+// uploadToOutputContainer uploads the processed file to the output container in the specified storage account
+func uploadToOutputContainer(ctx context.Context, storageAccount, outputContainer, newFilename, contentType string, excelBuffer *bytes.Buffer) error {
+	dest := blobLocation{Account: storageAccount, Container: outputContainer, Path: newFilename}
+	if err := storage.Upload(ctx, dest, excelBuffer.Bytes(), contentType); err != nil {
 		return fmt.Errorf("failed to upload processed file to output storage account: %w", err)
 	}
 
@@ -494,6 +1388,20 @@ func uploadToOutputContainer(ctx context.Context, cred *azidentity.ManagedIdenti
 	return nil
 }
 
+// processedFileName returns the output file name for an input blob URL. Dry runs
+// produce a plan workbook rather than a processed one.
+func processedFileName(blobURL string, dryRun bool) (string, error) {
+	originalFilename, err := extractFilenameFromURL(blobURL)
+	if err != nil {
+		return "", err
+	}
+	ext := filepath.Ext(originalFilename)
+	if dryRun {
+		return strings.TrimSuffix(originalFilename, ext) + "_plan" + ext, nil
+	}
+	return strings.TrimSuffix(originalFilename, ext) + "_processed" + ext, nil
+}
+
 // extractFilenameFromURL extracts filename from blob URL
 func extractFilenameFromURL(blobURL string) (string, error) {
 	parsedURL, err := url.Parse(blobURL)
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestParseAccessTier(t *testing.T) {
+	tests := []struct {
+		value string
+		want  blob.AccessTier
+	}{
+		{"", ""},
+		{"Hot", blob.AccessTierHot},
+		{" cool ", blob.AccessTierCool},
+		{"COLD", blob.AccessTierCold},
+		{"archive", blob.AccessTierArchive},
+	}
+	for _, tt := range tests {
+		got, err := parseAccessTier(tt.value)
+		if err != nil {
+			t.Errorf("parseAccessTier(%q): %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseAccessTier(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+
+	for _, value := range []string{"Premium", "P30", "Cool tier"} {
+		if tier, err := parseAccessTier(value); err == nil {
+			t.Errorf("parseAccessTier(%q) = %q, want an error", value, tier)
+		}
+	}
+}
+
+func TestParseRehydratePriority(t *testing.T) {
+	tests := []struct {
+		value string
+		want  blob.RehydratePriority
+	}{
+		{"", ""},
+		{"Standard", blob.RehydratePriorityStandard},
+		{" high ", blob.RehydratePriorityHigh},
+	}
+	for _, tt := range tests {
+		got, err := parseRehydratePriority(tt.value)
+		if err != nil {
+			t.Errorf("parseRehydratePriority(%q): %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseRehydratePriority(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+
+	for _, value := range []string{"Low", "Urgent"} {
+		if priority, err := parseRehydratePriority(value); err == nil {
+			t.Errorf("parseRehydratePriority(%q) = %q, want an error", value, priority)
+		}
+	}
+}
+
+func TestProcessedFileName(t *testing.T) {
+	tests := []struct {
+		url    string
+		dryRun bool
+		want   string
+	}{
+		{"https://acct.blob.core.windows.net/in/restore.xlsx", false, "restore_processed.xlsx"},
+		{"https://acct.blob.core.windows.net/in/dir/restore.xlsx", true, "restore_plan.xlsx"},
+		{"https://acct.blob.core.windows.net/in/restore.csv", false, "restore_processed.csv"},
+		{"https://acct.blob.core.windows.net/in/restore.json", true, "restore_plan.json"},
+	}
+	for _, tt := range tests {
+		got, err := processedFileName(tt.url, tt.dryRun)
+		if err != nil {
+			t.Errorf("processedFileName(%q, %v): %v", tt.url, tt.dryRun, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("processedFileName(%q, %v) = %q, want %q", tt.url, tt.dryRun, got, tt.want)
+		}
+	}
+}
+
+func TestSelectSheets(t *testing.T) {
+	f := excelize.NewFile()
+	defer f.Close()
+	for _, name := range []string{"Restore", "Notes"} {
+		if _, err := f.NewSheet(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		configured []string
+		want       []string
+	}{
+		{"every sheet", nil, []string{"Sheet1", "Restore", "Notes"}},
+		{"configured, case insensitive", []string{"restore", "missing"}, []string{"Restore"}},
+	}
+	for _, tt := range tests {
+		got, err := selectSheets(f, tt.configured)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: selectSheets = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := selectSheets(f, []string{"missing"}); err == nil {
+		t.Error("selectSheets succeeded without any configured sheet in the workbook")
+	}
+
+	// The workbook's own Sheets setting wins over the deployment's list
+	if err := f.SetDefinedName(&excelize.DefinedName{Name: "Sheets", RefersTo: `="Notes"`}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := selectSheets(f, []string{"Restore"}); err != nil || !slices.Equal(got, []string{"Notes"}) {
+		t.Errorf("selectSheets with a workbook setting = %v, %v; want [Notes]", got, err)
+	}
+}
+
+func TestFormatStats(t *testing.T) {
+	stats := newStats("changed")
+	stats["processed"], stats["changed"] = 3, 2
+	stats["Hot → Cool"], stats["Archive → Cool"] = 1, 1
+
+	want := "processed: 3, changed: 2, pending: 0, skipped: 0, errors: 0, Archive → Cool: 1, Hot → Cool: 1"
+	if got := formatStats(stats); got != want {
+		t.Errorf("formatStats = %q, want %q", got, want)
+	}
+}
+
+// batchCountingStorage is a memoryStorage that records the size of each tier batch
+type batchCountingStorage struct {
+	*memoryStorage
+	mu      sync.Mutex
+	batches []int
+}
+
+// SetTierBatch implements storageBackend
+func (s *batchCountingStorage) SetTierBatch(ctx context.Context, changes []tierChange) ([]error, error) {
+	s.mu.Lock()
+	s.batches = append(s.batches, len(changes))
+	s.mu.Unlock()
+	return s.memoryStorage.SetTierBatch(ctx, changes)
+}
+
+// useMemoryStorage points the globals processExcelBlob relies on at a fresh
+// memoryStorage, wrapped by the returned batchCountingStorage, with state kept in a
+// temporary directory. Standard priority rehydrations take delay.
+func useMemoryStorage(t *testing.T, delay time.Duration) *batchCountingStorage {
+	t.Helper()
+	t.Setenv("STATE_DIR", t.TempDir())
+	t.Setenv("OUTPUT_STORAGE_ACCOUNT", "acct")
+	t.Setenv("OUTPUT_STORAGE_CONTAINER", "out")
+	t.Setenv("ROW_MAX_ATTEMPTS", "1")
+
+	events, err := openEventStore(processedEventsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &batchCountingStorage{memoryStorage: newMemoryStorage(delay)}
+
+	prevStorage, prevEndpoints, prevPrices, prevMappings, prevEvents := storage, endpoints, prices, columnMappings, processedEvents
+	storage, endpoints, prices, columnMappings, processedEvents = s, defaultEndpoints(t), defaultPriceTable(), &columnMappingConfig{}, events
+	t.Cleanup(func() {
+		storage, endpoints, prices, columnMappings, processedEvents = prevStorage, prevEndpoints, prevPrices, prevMappings, prevEvents
+	})
+	return s
+}
+
+// resultValue returns the value under a result header in the zero-based row of a
+// sheet whose result columns start at the zero-based col
+func resultValue(t *testing.T, f *excelize.File, sheet string, row, col int, header string) string {
+	t.Helper()
+	cell, err := excelize.CoordinatesToCellName(col+slices.Index(resultHeaders, header)+1, row+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := f.GetCellValue(sheet, cell)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// expandedRow returns the zero-based row of the expansion sheet listing blob
+func expandedRow(t *testing.T, f *excelize.File, blob string) int {
+	t.Helper()
+	rows, err := f.GetRows(expansionSheetName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, row := range rows {
+		if len(row) > 3 && row[3] == blob {
+			return i
+		}
+	}
+	t.Fatalf("%s is not on the expansion sheet", blob)
+	return -1
+}
+
+// downloadOutput opens the processed workbook uploaded for manifest.xlsx
+func downloadOutput(t *testing.T) *excelize.File {
+	t.Helper()
+	out, err := storage.Download(context.Background(), blobLocation{Account: "acct", Container: "out", Path: "manifest_processed.xlsx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := loadManifest("manifest_processed.xlsx", out.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.File.Close() })
+	return m.File
+}
+
+func TestProcessExcelBlob(t *testing.T) {
+	const delay = time.Hour
+	t.Setenv("SET_TIER_BATCH_SIZE", "2")
+	s := useMemoryStorage(t, delay)
+	ctx := context.Background()
+
+	for path, tier := range map[string]blob.AccessTier{
+		"a.txt":      blob.AccessTierArchive,
+		"b.txt":      blob.AccessTierHot,
+		"c.txt":      blob.AccessTierHot,
+		"logs/1.log": blob.AccessTierHot,
+		"logs/2.log": blob.AccessTierArchive,
+	} {
+		s.put(blobLocation{Account: "acct", Container: "c", Path: path}, []byte("data"), "text/plain", tier)
+	}
+
+	f := excelize.NewFile()
+	rows := [][]interface{}{
+		{"URL", "Target Tier", "Rehydrate Priority"},
+		{"https://acct.blob.core.windows.net/c/a.txt", "Cool", "High"},
+		{"https://acct.blob.core.windows.net/c/b.txt", "Cool"},
+		{"https://acct.blob.core.windows.net/c/c.txt", "Cool"},
+		{"https://acct.blob.core.windows.net/c/logs/", "Cool"},
+		{"not a blob url", "Cool"},
+		{"https://acct.blob.core.windows.net/c/missing.txt", "Cool"},
+	}
+	for i, row := range rows {
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		if err := f.SetSheetRow("Sheet1", cell, &row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	input, err := f.WriteToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputURL := "https://acct.blob.core.windows.net/in/manifest.xlsx"
+	s.put(blobLocation{Account: "acct", Container: "in", Path: "manifest.xlsx"}, input.Bytes(), "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", blob.AccessTierHot)
+
+	report, err := processExcelBlob(ctx, inputURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := report.Stats["errors"]; got != 2 {
+		t.Errorf("errors = %d, want 2 (the invalid and the missing rows)", got)
+	}
+	if len(report.Rehydrations) != 2 {
+		t.Fatalf("tracking %d rehydrations, want 2", len(report.Rehydrations))
+	}
+	// The five tier changes, prefix matches included, are batched two to a request
+	if batches := slices.Sorted(slices.Values(s.batches)); !slices.Equal(batches, []int{1, 2, 2}) {
+		t.Errorf("tier batch sizes = %v, want [1 2 2]", batches)
+	}
+
+	out := downloadOutput(t)
+	const resultCol = 3
+	for _, tt := range []struct {
+		row    int
+		result string
+	}{
+		{1, resultChanged},
+		{2, resultChanged},
+		{4, resultExpanded},
+		{5, resultInvalidRow},
+		{6, resultNotFound},
+	} {
+		if got := resultValue(t, out, "Sheet1", tt.row, resultCol, "Result"); got != tt.result {
+			t.Errorf("row %d Result = %q, want %q", tt.row+1, got, tt.result)
+		}
+	}
+	if got := resultValue(t, out, "Sheet1", 1, resultCol, "Archive Status"); got != "rehydrate-pending-to-cool" {
+		t.Errorf("row 2 Archive Status = %q, want rehydrate-pending-to-cool", got)
+	}
+	if got := resultValue(t, out, "Sheet1", 1, resultCol, "Rehydrate Priority"); got != "High" {
+		t.Errorf("row 2 Rehydrate Priority = %q, want High", got)
+	}
+	pendingLog := expandedRow(t, out, "acct/c/logs/2.log")
+	if got := resultValue(t, out, expansionSheetName, pendingLog, len(expansionHeaders), "Archive Status"); got != "rehydrate-pending-to-cool" {
+		t.Errorf("logs/2.log Archive Status = %q, want rehydrate-pending-to-cool", got)
+	}
+
+	// Nothing has rehydrated yet, so the checker leaves the output alone
+	if err := checkRehydrations(ctx); err != nil {
+		t.Fatal(err)
+	}
+	trackerPath := rehydrationTrackerPath("manifest_processed.xlsx")
+	if _, err := os.Stat(trackerPath); err != nil {
+		t.Fatalf("tracker: %v", err)
+	}
+
+	later := time.Now().Add(2 * delay)
+	s.now = func() time.Time { return later }
+	if err := checkRehydrations(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(trackerPath); !os.IsNotExist(err) {
+		t.Errorf("tracker still exists after every rehydration completed: %v", err)
+	}
+
+	out = downloadOutput(t)
+	if got := resultValue(t, out, "Sheet1", 1, resultCol, "Result"); got != resultRehydrated {
+		t.Errorf("row 2 Result = %q after rehydration, want %q", got, resultRehydrated)
+	}
+	if got := resultValue(t, out, "Sheet1", 1, resultCol, "Rehydrate Priority"); got != "High" {
+		t.Errorf("row 2 Rehydrate Priority = %q after rehydration, want High", got)
+	}
+	pendingLog = expandedRow(t, out, "acct/c/logs/2.log")
+	if got := resultValue(t, out, expansionSheetName, pendingLog, len(expansionHeaders), "Result"); got != resultRehydrated {
+		t.Errorf("logs/2.log Result = %q after rehydration, want %q", got, resultRehydrated)
+	}
+	if got := resultValue(t, out, "Sheet1", 2, resultCol, "Result"); got != resultChanged {
+		t.Errorf("row 3 Result = %q after the checker ran, want it kept as %q", got, resultChanged)
+	}
+}
+
+func TestProcessExcelBlobDropsEarlierTracker(t *testing.T) {
+	s := useMemoryStorage(t, time.Hour)
+	ctx := context.Background()
+	s.put(blobLocation{Account: "acct", Container: "c", Path: "a.txt"}, []byte("data"), "text/plain", blob.AccessTierArchive)
+
+	earlier := &rehydrationTracker{
+		OutputAccount:   "acct",
+		OutputContainer: "out",
+		OutputFile:      "manifest_processed.csv",
+		Rehydrations:    []rehydrationRecord{{Account: "acct", Container: "c", BlobPath: "a.txt", FromTier: "Archive", ToTier: "Cool"}},
+	}
+	if err := saveRehydrationTracker(earlier); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := "URL,Target Tier\nhttps://acct.blob.core.windows.net/c/a.txt,Archive\n"
+	s.put(blobLocation{Account: "acct", Container: "in", Path: "manifest.csv"}, []byte(manifest), "text/csv", blob.AccessTierHot)
+	if _, err := processExcelBlob(ctx, "https://acct.blob.core.windows.net/in/manifest.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(rehydrationTrackerPath(earlier.OutputFile)); !os.IsNotExist(err) {
+		t.Errorf("the tracker of the replaced output was kept: %v", err)
+	}
+}
+
+func TestProcessExcelBlobIgnoresSheetsForCSV(t *testing.T) {
+	t.Setenv("PROCESS_SHEETS", "Restore")
+	s := useMemoryStorage(t, time.Hour)
+	s.put(blobLocation{Account: "acct", Container: "c", Path: "a.txt"}, []byte("data"), "text/plain", blob.AccessTierHot)
+
+	manifest := "URL,Target Tier\nhttps://acct.blob.core.windows.net/c/a.txt,Cool\n"
+	s.put(blobLocation{Account: "acct", Container: "in", Path: "manifest.csv"}, []byte(manifest), "text/csv", blob.AccessTierHot)
+	report, err := processExcelBlob(context.Background(), "https://acct.blob.core.windows.net/in/manifest.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := report.Stats["changed"]; got != 1 {
+		t.Errorf("changed = %d, want 1", got)
+	}
+}
+
+func TestProcessExcelBlobTracksCSVPrefixRehydrations(t *testing.T) {
+	const delay = time.Hour
+	s := useMemoryStorage(t, delay)
+	ctx := context.Background()
+	s.put(blobLocation{Account: "acct", Container: "c", Path: "logs/1.log"}, []byte("data"), "text/plain", blob.AccessTierHot)
+	s.put(blobLocation{Account: "acct", Container: "c", Path: "logs/2.log"}, []byte("data"), "text/plain", blob.AccessTierArchive)
+
+	manifest := "URL,Target Tier\nhttps://acct.blob.core.windows.net/c/logs/,Cool\n"
+	s.put(blobLocation{Account: "acct", Container: "in", Path: "manifest.csv"}, []byte(manifest), "text/csv", blob.AccessTierHot)
+	report, err := processExcelBlob(ctx, "https://acct.blob.core.windows.net/in/manifest.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Rehydrations) != 1 {
+		t.Fatalf("tracking %d rehydrations, want 1", len(report.Rehydrations))
+	}
+
+	// CSV has no expansion sheet, so the prefix row's Details count the rehydrations
+	details := func() string {
+		out, err := storage.Download(ctx, blobLocation{Account: "acct", Container: "out", Path: "manifest_processed.csv"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := loadManifest("manifest_processed.csv", out.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer m.File.Close()
+		return resultValue(t, m.File, manifestSheet, 1, 2, "Details")
+	}
+	if got := details(); !strings.HasSuffix(got, "; 0 of 1 rehydrations finished") {
+		t.Errorf("prefix row Details = %q, want the pending rehydration counted", got)
+	}
+
+	later := time.Now().Add(2 * delay)
+	s.now = func() time.Time { return later }
+	if err := checkRehydrations(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := details(); !strings.HasPrefix(got, "Expanded: 2 blobs") || !strings.HasSuffix(got, "; 1 of 1 rehydrations finished") {
+		t.Errorf("prefix row Details = %q after rehydration, want the rehydration counted as finished", got)
+	}
+	if _, err := os.Stat(rehydrationTrackerPath("manifest_processed.csv")); !os.IsNotExist(err) {
+		t.Errorf("tracker still exists after every rehydration completed: %v", err)
+	}
+}
+
+func TestCheckRehydrationsStopsTrackingDeletedBlobs(t *testing.T) {
+	s := useMemoryStorage(t, time.Hour)
+	ctx := context.Background()
+	loc := blobLocation{Account: "acct", Container: "c", Path: "a.txt"}
+	s.put(loc, []byte("data"), "text/plain", blob.AccessTierArchive)
+
+	manifest := "URL,Target Tier\nhttps://acct.blob.core.windows.net/c/a.txt,Cool\n"
+	s.put(blobLocation{Account: "acct", Container: "in", Path: "manifest.csv"}, []byte(manifest), "text/csv", blob.AccessTierHot)
+	if _, err := processExcelBlob(ctx, "https://acct.blob.core.windows.net/in/manifest.csv"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	delete(s.containers[s.containerKey(loc)], loc.Path)
+	s.mu.Unlock()
+	if err := checkRehydrations(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(rehydrationTrackerPath("manifest_processed.csv")); !os.IsNotExist(err) {
+		t.Errorf("tracker of a deleted blob was kept: %v", err)
+	}
+
+	out, err := storage.Download(ctx, blobLocation{Account: "acct", Container: "out", Path: "manifest_processed.csv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := loadManifest("manifest_processed.csv", out.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.File.Close()
+	if got := resultValue(t, m.File, manifestSheet, 1, 2, "Result"); got != resultNotFound {
+		t.Errorf("Result = %q, want %q", got, resultNotFound)
+	}
+	if got := resultValue(t, m.File, manifestSheet, 1, 2, "Error"); !strings.HasPrefix(got, "Poll: BlobNotFound") {
+		t.Errorf("Error = %q, want the failed poll", got)
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Supported manifest formats, keyed by file extension
+const (
+	formatXLSX = ".xlsx"
+	formatCSV  = ".csv"
+	formatJSON = ".json"
+)
+
+// manifestSheet is the worksheet CSV and JSON manifests are loaded into
+const manifestSheet = "Sheet1"
+
+// manifest is an input file loaded into a workbook so every format shares the
+// Excel processing path. CSV and JSON manifests are converted back on encode.
+type manifest struct {
+	Format string
+	File   *excelize.File
+	// records keeps the original JSON objects so untouched values round-trip as-is
+	records []jsonRecord
+}
+
+// manifestFormat returns the manifest format of a file name
+func manifestFormat(name string) (string, error) {
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case formatXLSX, formatCSV, formatJSON:
+		return ext, nil
+	default:
+		return "", fmt.Errorf("unsupported manifest type %q (expected .xlsx, .csv or .json)", ext)
+	}
+}
+
+// loadManifest opens a manifest, choosing the format from its file name
+func loadManifest(name string, data []byte) (*manifest, error) {
+	format, err := manifestFormat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case formatCSV:
+		return loadCSVManifest(data)
+	case formatJSON:
+		return loadJSONManifest(data)
+	default:
+		f, err := excelize.OpenReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open excel file: %w", err)
+		}
+		return &manifest{Format: formatXLSX, File: f}, nil
+	}
+}
+
+// loadCSVManifest loads a CSV file whose first record is the header row
+func loadCSVManifest(data []byte) (*manifest, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read csv file: %w", err)
+	}
+
+	m := &manifest{Format: formatCSV, File: excelize.NewFile()}
+	for i, record := range records {
+		if err := setManifestRow(m.File, i, record); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// loadJSONManifest loads a JSON array of objects, one per row, or of plain URL strings
+func loadJSONManifest(data []byte) (*manifest, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("failed to read json file: expected an array: %w", err)
+	}
+
+	m := &manifest{Format: formatJSON, File: excelize.NewFile()}
+	var headers []string
+	for i, item := range items {
+		var record jsonRecord
+		var url string
+		if err := json.Unmarshal(item, &url); err == nil {
+			record = jsonRecord{keys: []string{"url"}, values: map[string]json.RawMessage{"url": item}}
+		} else if err := json.Unmarshal(item, &record); err != nil {
+			return nil, fmt.Errorf("failed to read json file: item %d: %w", i, err)
+		}
+
+		// New keys go after the record's preceding key, so a column keeps its place
+		// even when earlier records lack some keys
+		prev := -1
+		for _, key := range record.keys {
+			idx := slices.Index(headers, key)
+			if idx == -1 {
+				idx = prev + 1
+				headers = slices.Insert(headers, idx, key)
+			}
+			prev = idx
+		}
+		m.records = append(m.records, record)
+	}
+
+	if err := setManifestRow(m.File, 0, headers); err != nil {
+		return nil, err
+	}
+	for i, record := range m.records {
+		row := make([]string, len(headers))
+		for col, key := range headers {
+			row[col] = record.cell(key)
+		}
+		if err := setManifestRow(m.File, i+1, row); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// setManifestRow writes values as text into the zero-based row of the manifest sheet
+func setManifestRow(f *excelize.File, rowIndex int, values []string) error {
+	cell, err := excelize.CoordinatesToCellName(1, rowIndex+1)
+	if err != nil {
+		return err
+	}
+
+	row := make([]interface{}, len(values))
+	for i, v := range values {
+		row[i] = v
+	}
+	return f.SetSheetRow(manifestSheet, cell, &row)
+}
+
+// encode serializes the processed manifest in its original format
+func (m *manifest) encode() (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	switch m.Format {
+	case formatCSV:
+		rows, err := m.rows()
+		if err != nil {
+			return nil, err
+		}
+		writer := csv.NewWriter(&buf)
+		if err := writer.WriteAll(rows); err != nil {
+			return nil, fmt.Errorf("failed to write csv: %w", err)
+		}
+	case formatJSON:
+		if err := m.encodeJSON(&buf); err != nil {
+			return nil, err
+		}
+	default:
+		if err := m.File.Write(&buf); err != nil {
+			return nil, fmt.Errorf("failed to write excel to buffer: %w", err)
+		}
+	}
+	return &buf, nil
+}
+
+// rows returns the manifest sheet padded to the width of its header row
+func (m *manifest) rows() ([][]string, error) {
+	rows, err := m.File.GetRows(manifestSheet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rows from sheet %s: %w", manifestSheet, err)
+	}
+	if len(rows) == 0 {
+		return rows, nil
+	}
+
+	width := len(rows[0])
+	for i := range rows {
+		for len(rows[i]) < width {
+			rows[i] = append(rows[i], "")
+		}
+	}
+	return rows, nil
+}
+
+// encodeJSON writes one object per record, keeping the input keys and values and
+// appending the result columns. Result columns are written to every record, empty or
+// not, so records share one schema and the columns keep their positions when the
+// output is loaded again by the rehydration checker.
+func (m *manifest) encodeJSON(w io.Writer) error {
+	rows, err := m.rows()
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		_, err := io.WriteString(w, "[]\n")
+		return err
+	}
+
+	headers := rows[0]
+	out := make([]jsonRecord, len(m.records))
+	for i, record := range m.records {
+		var row []string
+		if i+1 < len(rows) {
+			row = rows[i+1]
+		}
+
+		out[i] = jsonRecord{values: make(map[string]json.RawMessage)}
+		for col, key := range headers {
+			value := cellValue(row, col)
+			if _, ok := record.values[key]; ok && record.cell(key) == value {
+				out[i].set(key, record.values[key])
+				continue
+			}
+			if value == "" && !slices.Contains(resultHeaders, key) {
+				continue
+			}
+			raw, err := resultJSONValue(key, value)
+			if err != nil {
+				return err
+			}
+			out[i].set(key, raw)
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(out)
+}
+
+// resultJSONValue encodes a cell value for a JSON manifest: numeric result columns as
+// numbers, or null when empty, and everything else as a string
+func resultJSONValue(key, value string) (json.RawMessage, error) {
+	if slices.Contains(numericResultHeaders, key) {
+		if value == "" {
+			return json.RawMessage("null"), nil
+		}
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return json.Marshal(n)
+		}
+	}
+	return json.Marshal(value)
+}
+
+// contentType returns the MIME type of the manifest's format
+func (m *manifest) contentType() string {
+	switch m.Format {
+	case formatCSV:
+		return "text/csv"
+	case formatJSON:
+		return "application/json"
+	default:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+}
+
+// jsonRecord is a JSON object that remembers its key order, so columns keep their
+// positions when a JSON manifest is processed and later re-read
+type jsonRecord struct {
+	keys   []string
+	values map[string]json.RawMessage
+}
+
+// set adds or replaces a key, appending new keys at the end
+func (r *jsonRecord) set(key string, value json.RawMessage) {
+	if _, ok := r.values[key]; !ok {
+		r.keys = append(r.keys, key)
+	}
+	r.values[key] = value
+}
+
+// cell returns the value of key as sheet text: strings unquoted, null and missing as ""
+func (r jsonRecord) cell(key string) string {
+	raw, ok := r.values[key]
+	if !ok {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	if string(raw) == "null" {
+		return ""
+	}
+	return string(raw)
+}
+
+// UnmarshalJSON decodes an object, keeping its keys in document order
+func (r *jsonRecord) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("expected an object or a string")
+	}
+
+	r.keys = nil
+	r.values = make(map[string]json.RawMessage)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := tok.(string)
+
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+		r.set(key, value)
+	}
+	return nil
+}
+
+// MarshalJSON encodes the object with its keys in insertion order
+func (r jsonRecord) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range r.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		encodedKey, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(encodedKey)
+		buf.WriteByte(':')
+		buf.Write(r.values[key])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadCSVManifest(t *testing.T) {
+	input := "\xef\xbb\xbfURL,Tier\nhttps://acct.blob.core.windows.net/c/a.txt,Cool\nhttps://acct.blob.core.windows.net/c/b.txt\n"
+	m, err := loadManifest("restore.csv", []byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.File.Close()
+
+	rows, err := m.rows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"URL", "Tier"},
+		{"https://acct.blob.core.windows.net/c/a.txt", "Cool"},
+		{"https://acct.blob.core.windows.net/c/b.txt", ""},
+	}
+	if !slices.EqualFunc(rows, want, slices.Equal) {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+
+	if err := m.File.SetCellValue(manifestSheet, "C1", "Status"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := m.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "URL,Tier,Status\nhttps://acct.blob.core.windows.net/c/a.txt,Cool,\nhttps://acct.blob.core.windows.net/c/b.txt,,\n"; got != want {
+		t.Errorf("encoded csv = %q, want %q", got, want)
+	}
+}
+
+func TestLoadJSONManifest(t *testing.T) {
+	input := `[
+  {"url": "https://acct.blob.core.windows.net/c/a.txt", "tier": "Cool", "ticket": 42},
+  {"url": "https://acct.blob.core.windows.net/c/b.txt", "owner": "ops", "tier": "Cold"},
+  "https://acct.blob.core.windows.net/c/c.txt"
+]`
+	m, err := loadManifest("restore.json", []byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.File.Close()
+
+	rows, err := m.rows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A key first seen in a later record goes after the key preceding it there
+	if want := []string{"url", "owner", "tier", "ticket"}; !slices.Equal(rows[0], want) {
+		t.Errorf("headers = %q, want %q", rows[0], want)
+	}
+	if want := []string{"https://acct.blob.core.windows.net/c/c.txt", "", "", ""}; !slices.Equal(rows[3], want) {
+		t.Errorf("plain URL row = %q, want %q", rows[3], want)
+	}
+
+	if err := m.File.SetSheetRow(manifestSheet, "E1", &[]interface{}{"Status"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.File.SetCellValue(manifestSheet, "E2", "Changed"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := m.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Input values keep their JSON types and added columns follow the input keys
+	for _, want := range []string{`"ticket": 42`, `"ticket": 42,
+    "Status": "Changed"`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("encoded json lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestLoadManifestRejectsUnknownFormats(t *testing.T) {
+	if _, err := loadManifest("restore.txt", []byte("https://acct.blob.core.windows.net/c/a.txt")); err == nil {
+		t.Error("loadManifest accepted a .txt manifest")
+	}
+	if _, err := loadManifest("restore.json", []byte(`{"url": "x"}`)); err == nil {
+		t.Error("loadManifest accepted a JSON object instead of an array")
+	}
+}
+
+func TestJSONManifestKeepsResultColumns(t *testing.T) {
+	input := `[
+  {"url": "https://acct.blob.core.windows.net/c/a.txt", "tier": "Cool"},
+  {"url": "https://acct.blob.core.windows.net/c/b.txt"}
+]`
+	m, err := loadJSONManifest([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const resultCol = 2
+	if err := writeResultHeaders(m.File, manifestSheet, 0, resultCol); err != nil {
+		t.Fatal(err)
+	}
+	for row := 1; row <= 2; row++ {
+		r := rowResult{Code: resultPending, Timestamp: time.Now(), Details: "Pending"}
+		if err := writeRowResult(m.File, manifestSheet, row, resultCol, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, err := m.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The rehydration checker loads the output again and writes to the same columns
+	republished, err := loadJSONManifest(out.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := republished.rows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append([]string{"url", "tier"}, resultHeaders...); !slices.Equal(rows[0], want) {
+		t.Fatalf("republished headers = %q, want %q", rows[0], want)
+	}
+	details := resultCol + slices.Index(resultHeaders, "Details")
+	for row := 1; row <= 2; row++ {
+		if got := rows[row][resultCol]; got != resultPending {
+			t.Errorf("row %d Result = %q, want %q", row, got, resultPending)
+		}
+		if got := rows[row][details]; got != "Pending" {
+			t.Errorf("row %d Details = %q, want Pending", row, got)
+		}
+	}
+	if got := rows[2][1]; got != "" {
+		t.Errorf("row 2 tier = %q, want it left empty", got)
+	}
+}
+
+func TestJSONManifestResultTypes(t *testing.T) {
+	m, err := loadJSONManifest([]byte(`[{"url": "https://acct.blob.core.windows.net/c/a.txt"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const resultCol = 1
+	if err := writeResultHeaders(m.File, manifestSheet, 0, resultCol); err != nil {
+		t.Fatal(err)
+	}
+	r := rowResult{Code: resultPending, Attempts: 1, Details: "Pending", Cost: &costEstimate{SizeGB: 0.5, Rehydration: 0.00055, Total: 0.00055}}
+	if err := writeRowResult(m.File, manifestSheet, 1, resultCol, r); err != nil {
+		t.Fatal(err)
+	}
+	out, err := m.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"Attempts": 1,`, `"Size (GB)": 0.5,`, `"Est. Read": 0,`, `"Est. Total": 0.00055`, `"Error": "",`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("encoded json lacks %q:\n%s", want, out)
+		}
+	}
+
+	// A republished result whose columns became empty keeps every result key
+	republished, err := loadJSONManifest(out.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeRowResult(republished.File, manifestSheet, 1, resultCol, rowResult{Code: resultNotFound, Error: "Poll: BlobNotFound (HTTP 404)"}); err != nil {
+		t.Fatal(err)
+	}
+	out, err = republished.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"Attempts": null,`, `"Details": "",`, `"Est. Total": null`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("republished json lacks %q:\n%s", want, out)
+		}
+	}
+}
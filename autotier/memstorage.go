@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+)
+
+// memoryStorage is an in-memory storageBackend. It simulates access tiers, Archive
+// rehydration and copies, and fails with the same error codes as Azure Storage, so
+// results are classified as they would be against a real account.
+type memoryStorage struct {
+	// rehydrationDelay is how long a Standard priority rehydration takes; High
+	// priority takes a fifteenth of it
+	rehydrationDelay time.Duration
+	// now is the clock, replaceable to step through rehydrations
+	now func() time.Time
+
+	mu sync.Mutex
+	// containers maps containerKey to the container's blobs by objectKey
+	containers map[string]map[string]*memoryBlob
+	etag       int
+	copies     int
+}
+
+// memoryBlob is a blob held by memoryStorage
+type memoryBlob struct {
+	data         []byte
+	contentType  string
+	metadata     map[string]*string
+	etag         string
+	lastModified time.Time
+
+	tier           blob.AccessTier
+	tierChangeTime *time.Time
+	// rehydrateTo and rehydrateDone describe a rehydration in progress
+	rehydrateTo       blob.AccessTier
+	rehydratePriority blob.RehydratePriority
+	rehydrateDone     time.Time
+
+	copyID         string
+	copyStatus     blob.CopyStatusType
+	copyDone       time.Time
+	copyCompletion *time.Time
+}
+
+// newMemoryStorage creates an empty memoryStorage
+func newMemoryStorage(rehydrationDelay time.Duration) *memoryStorage {
+	return &memoryStorage{
+		rehydrationDelay: rehydrationDelay,
+		now:              time.Now,
+		containers:       make(map[string]map[string]*memoryBlob),
+	}
+}
+
+// memorySeedBlob is an entry of a memory storage seed file
+type memorySeedBlob struct {
+	URL  string `json:"url"`
+	Tier string `json:"tier"`
+	// Content is the blob content, or File names a local file to read it from
+	Content     string            `json:"content"`
+	File        string            `json:"file"`
+	ContentType string            `json:"contentType"`
+	Metadata    map[string]string `json:"metadata"`
+}
+
+// seedFile adds the blobs listed in a JSON seed file: an array of memorySeedBlob
+func (m *memoryStorage) seedFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var seeds []memorySeedBlob
+	if err := json.Unmarshal(data, &seeds); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	for _, s := range seeds {
+		loc, err := parseBlobRef(s.URL)
+		if err != nil {
+			return err
+		}
+
+		content := []byte(s.Content)
+		if s.File != "" {
+			if content, err = os.ReadFile(s.File); err != nil {
+				return err
+			}
+		}
+
+		tier := blob.AccessTierHot
+		if s.Tier != "" {
+			if tier, err = parseAccessTier(s.Tier); err != nil {
+				return fmt.Errorf("%s: %w", s.URL, err)
+			}
+		}
+
+		b := m.put(loc, content, s.ContentType, tier)
+		for k, v := range s.Metadata {
+			b.metadata[k] = &v
+		}
+	}
+	return nil
+}
+
+// put stores a blob, replacing any existing one, and returns it
+func (m *memoryStorage) put(loc blobLocation, data []byte, contentType string, tier blob.AccessTier) *memoryBlob {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := m.containerKey(loc)
+	if m.containers[key] == nil {
+		m.containers[key] = make(map[string]*memoryBlob)
+	}
+	b := &memoryBlob{
+		data:         data,
+		contentType:  contentType,
+		metadata:     make(map[string]*string),
+		etag:         m.nextETag(),
+		lastModified: m.now().UTC(),
+		tier:         tier,
+	}
+	m.containers[key][m.objectKey(loc)] = b
+	return b
+}
+
+// containerKey identifies the container of loc across accounts
+func (m *memoryStorage) containerKey(loc blobLocation) string {
+	return loc.Account + "/" + loc.Container
+}
+
+// objectKey identifies the blob, version or snapshot of loc within its container.
+// Versions and snapshots are stored as objects of their own, seeded with their URL.
+func (m *memoryStorage) objectKey(loc blobLocation) string {
+	if q := loc.query(); q != "" {
+		return loc.Path + "\x00" + q
+	}
+	return loc.Path
+}
+
+// nextETag returns a new ETag. The caller holds m.mu.
+func (m *memoryStorage) nextETag() string {
+	m.etag++
+	return fmt.Sprintf("\"0x%X\"", m.etag)
+}
+
+// lookup returns the blob at loc after advancing its simulated rehydration and copy.
+// The caller holds m.mu.
+func (m *memoryStorage) lookup(loc blobLocation) (*memoryBlob, error) {
+	blobs, ok := m.containers[m.containerKey(loc)]
+	if !ok {
+		return nil, memoryError(bloberror.ContainerNotFound, http.StatusNotFound)
+	}
+	b, ok := blobs[m.objectKey(loc)]
+	if !ok {
+		return nil, memoryError(bloberror.BlobNotFound, http.StatusNotFound)
+	}
+
+	now := m.now().UTC()
+	if b.rehydrateTo != "" && !now.Before(b.rehydrateDone) {
+		b.tier = b.rehydrateTo
+		b.rehydrateTo, b.rehydratePriority = "", ""
+		b.tierChangeTime = &b.rehydrateDone
+	}
+	if b.copyStatus == blob.CopyStatusTypePending && !now.Before(b.copyDone) {
+		b.copyStatus = blob.CopyStatusTypeSuccess
+		b.copyCompletion = &b.copyDone
+	}
+	return b, nil
+}
+
+// rehydrationTime returns how long a rehydration at priority takes
+func (m *memoryStorage) rehydrationTime(priority blob.RehydratePriority) time.Duration {
+	if priority == blob.RehydratePriorityHigh {
+		return m.rehydrationDelay / 15
+	}
+	return m.rehydrationDelay
+}
+
+// properties returns the blobProperties of b
+func (b *memoryBlob) properties() blobProperties {
+	props := blobProperties{
+		AccessTier:           b.tier,
+		AccessTierChangeTime: b.tierChangeTime,
+		ETag:                 b.etag,
+		ContentLength:        int64(len(b.data)),
+		LastModified:         b.lastModified,
+		CopyID:               b.copyID,
+		CopyStatus:           b.copyStatus,
+		CopyCompletionTime:   b.copyCompletion,
+	}
+	if b.rehydrateTo != "" {
+		props.ArchiveStatus = archiveStatusPendingPrefix + strings.ToLower(string(b.rehydrateTo))
+		props.RehydratePriority = b.rehydratePriority
+	}
+	if b.copyStatus == blob.CopyStatusTypeSuccess {
+		props.CopyProgress = fmt.Sprintf("%d/%d", len(b.data), len(b.data))
+	} else if b.copyStatus == blob.CopyStatusTypePending {
+		props.CopyProgress = fmt.Sprintf("0/%d", len(b.data))
+	}
+	return props
+}
+
+// GetProperties implements storageBackend
+func (m *memoryStorage) GetProperties(ctx context.Context, loc blobLocation) (blobProperties, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, err := m.lookup(loc)
+	if err != nil {
+		return blobProperties{}, err
+	}
+	props := b.properties()
+	props.VersionID = loc.VersionID
+	return props, nil
+}
+
+// SetTier implements storageBackend
+func (m *memoryStorage) SetTier(ctx context.Context, loc blobLocation, tier blob.AccessTier, priority blob.RehydratePriority) error {
+	if _, err := parseAccessTier(string(tier)); err != nil {
+		return memoryError(bloberror.InvalidHeaderValue, http.StatusBadRequest)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, err := m.lookup(loc)
+	if err != nil {
+		return err
+	}
+	if b.rehydrateTo != "" {
+		return memoryError(bloberror.BlobBeingRehydrated, http.StatusConflict)
+	}
+
+	now := m.now().UTC()
+	if b.tier == blob.AccessTierArchive && tier != blob.AccessTierArchive {
+		if priority == "" {
+			priority = blob.RehydratePriorityStandard
+		}
+		b.rehydrateTo = tier
+		b.rehydratePriority = priority
+		b.rehydrateDone = now.Add(m.rehydrationTime(priority))
+		return nil
+	}
+
+	b.tier = tier
+	b.tierChangeTime = &now
+	return nil
+}
+
+// SetTierBatch implements storageBackend
+func (m *memoryStorage) SetTierBatch(ctx context.Context, changes []tierChange) ([]error, error) {
+	errs := make([]error, len(changes))
+	for i, c := range changes {
+		errs[i] = m.SetTier(ctx, c.Location, c.Tier, c.Priority)
+	}
+	return errs, nil
+}
+
+// StartCopy implements storageBackend. Copies out of Archive stay pending for the
+// rehydration time; other copies complete immediately.
+func (m *memoryStorage) StartCopy(ctx context.Context, source, dest blobLocation, tier blob.AccessTier, priority blob.RehydratePriority) (copyInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	src, err := m.lookup(source)
+	if err != nil {
+		return copyInfo{}, err
+	}
+	if existing, err := m.lookup(dest); err == nil && existing.copyStatus == blob.CopyStatusTypePending {
+		return copyInfo{}, memoryError(bloberror.PendingCopyOperation, http.StatusConflict)
+	}
+	if _, ok := m.containers[m.containerKey(dest)]; !ok {
+		return copyInfo{}, memoryError(bloberror.ContainerNotFound, http.StatusNotFound)
+	}
+
+	now := m.now().UTC()
+	m.copies++
+	b := &memoryBlob{
+		data:         slices.Clone(src.data),
+		contentType:  src.contentType,
+		metadata:     make(map[string]*string),
+		etag:         m.nextETag(),
+		lastModified: now,
+		tier:         tier,
+		copyID:       fmt.Sprintf("memory-copy-%d", m.copies),
+		copyStatus:   blob.CopyStatusTypeSuccess,
+		copyDone:     now,
+	}
+	if src.tier == blob.AccessTierArchive && tier != blob.AccessTierArchive {
+		b.copyStatus = blob.CopyStatusTypePending
+		b.copyDone = now.Add(m.rehydrationTime(priority))
+	} else {
+		b.copyCompletion = &now
+	}
+	m.containers[m.containerKey(dest)][m.objectKey(dest)] = b
+
+	return copyInfo{ID: b.copyID, Status: b.copyStatus}, nil
+}
+
+// Download implements storageBackend
+func (m *memoryStorage) Download(ctx context.Context, loc blobLocation) (*downloadedBlob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, err := m.lookup(loc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download blob: %w", err)
+	}
+	if b.tier == blob.AccessTierArchive {
+		return nil, fmt.Errorf("failed to download blob: %w", memoryError(bloberror.BlobArchived, http.StatusConflict))
+	}
+	return &downloadedBlob{Data: slices.Clone(b.data), Metadata: b.metadata, ETag: b.etag}, nil
+}
+
+// Upload implements storageBackend
+func (m *memoryStorage) Upload(ctx context.Context, loc blobLocation, data []byte, contentType string) error {
+	m.put(loc, slices.Clone(data), contentType, blob.AccessTierHot)
+	return nil
+}
+
+// List implements storageBackend. Only base blobs are listed.
+func (m *memoryStorage) List(ctx context.Context, loc blobLocation, limit int) ([]blobItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	blobs, ok := m.containers[m.containerKey(loc)]
+	if !ok {
+		return nil, memoryError(bloberror.ContainerNotFound, http.StatusNotFound)
+	}
+
+	var paths []string
+	for path := range blobs {
+		if strings.HasPrefix(path, loc.Path) && !strings.Contains(path, "\x00") {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+	if limit > 0 && len(paths) > limit {
+		paths = paths[:limit]
+	}
+
+	items := make([]blobItem, 0, len(paths))
+	for _, path := range paths {
+		itemLoc := loc.base()
+		itemLoc.Path = path
+		b, err := m.lookup(itemLoc)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, blobItem{Location: itemLoc, Properties: b.properties()})
+	}
+	return items, nil
+}
+
+// memoryError returns the error Azure Storage responds with for code and status
+func memoryError(code bloberror.Code, status int) error {
+	return &storageError{Code: string(code), StatusCode: status}
+}
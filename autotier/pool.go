@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// rowTask is a manifest row ready to be passed to processBlobTier
+type rowTask struct {
+	RowIndex int
+	Source   blobLocation
+	Req      tierRequest
+	// Err is set when the row's options could not be parsed; the blob is not touched
+	Err error
+}
+
+// rowOutcome is the result of processing a rowTask
+type rowOutcome struct {
+	Result tierResult
+	Err    error
+	// Attempts is the number of times the row was tried; 0 for rows never tried
+	Attempts int
+}
+
+// runRowTasks processes tasks with a pool of workers, allowing at most perAccount
+// concurrent requests against any one storage account and retrying transient
+// failures under retry. With a batchSize above one, tier changes are deferred and
+// submitted as Blob Batch requests once every row has been looked up. Outcomes are
+// returned in task order so callers can write them back row by row. Once ctx is done
+// the remaining rows fail with its error.
+func runRowTasks(ctx context.Context, tasks []rowTask, workers, perAccount, batchSize int, retry retryPolicy) []rowOutcome {
+	outcomes := make([]rowOutcome, len(tasks))
+	limiter := newAccountLimiter(perAccount)
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				task := tasks[i]
+				if task.Err != nil {
+					outcomes[i] = rowOutcome{Err: task.Err}
+					continue
+				}
+				if err := ctx.Err(); err != nil {
+					outcomes[i] = rowOutcome{Err: err}
+					continue
+				}
+
+				// The account slot is held through backoffs, easing off a throttled account
+				req := task.Req
+				req.Batch = batchSize > 1
+				release := limiter.acquire(task.Source.Account)
+				result, attempts, err := retry.do(ctx, task.Source.String(), func() (tierResult, error) {
+					return processBlobTier(ctx, task.Source, req)
+				})
+				release()
+				outcomes[i] = rowOutcome{Result: result, Err: err, Attempts: attempts}
+			}
+		}()
+	}
+
+	for i := range tasks {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	if batchSize > 1 {
+		runTierBatches(ctx, tasks, outcomes, batchSize, workers, perAccount, retry)
+	}
+	return outcomes
+}
+
+// accountLimiter bounds the number of concurrent operations per storage account
+type accountLimiter struct {
+	mu    sync.Mutex
+	limit int
+	slots map[string]chan struct{}
+}
+
+// newAccountLimiter creates a limiter allowing limit concurrent operations per account
+func newAccountLimiter(limit int) *accountLimiter {
+	return &accountLimiter{limit: limit, slots: make(map[string]chan struct{})}
+}
+
+// acquire blocks until a slot for account is free and returns the function releasing it
+func (l *accountLimiter) acquire(account string) func() {
+	l.mu.Lock()
+	slot, ok := l.slots[account]
+	if !ok {
+		slot = make(chan struct{}, l.limit)
+		l.slots[account] = slot
+	}
+	l.mu.Unlock()
+
+	slot <- struct{}{}
+	return func() { <-slot }
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// headerScanRows bounds how far down a worksheet detectHeaderRow looks for the header
+const headerScanRows = 20
+
+// sheetRegion is the part of a worksheet holding the manifest table
+type sheetRegion struct {
+	// HeaderRow and LastRow are the zero-based header and last data rows
+	HeaderRow int
+	LastRow   int
+	// FirstCol and LastCol are the zero-based columns of an Excel table; the rows of
+	// other regions are used in full
+	FirstCol int
+	LastCol  int
+	// Table is the name of the Excel table the region covers, if any
+	Table string
+}
+
+// manifestRegions returns where the manifest table of a worksheet may be, most likely
+// first. A header row set by the column mapping is the only candidate; otherwise each
+// Excel table on the sheet is tried before the rows below the detected header row.
+func manifestRegions(f *excelize.File, sheetName string, rows [][]string, mappedHeaderRow int) []sheetRegion {
+	if mappedHeaderRow > 0 {
+		return []sheetRegion{{HeaderRow: mappedHeaderRow - 1, LastRow: len(rows) - 1, LastCol: -1}}
+	}
+
+	var regions []sheetRegion
+	tables, err := f.GetTables(sheetName)
+	if err != nil {
+		log.Printf("Failed to read the tables of sheet %s: %v", sheetName, err)
+	}
+	for _, t := range tables {
+		region, err := tableRegion(t)
+		if err != nil {
+			log.Printf("Ignoring table %s of sheet %s: %v", t.Name, sheetName, err)
+			continue
+		}
+		if region.HeaderRow < len(rows) {
+			regions = append(regions, region)
+		}
+	}
+	return append(regions, sheetRegion{HeaderRow: detectHeaderRow(rows), LastRow: len(rows) - 1, LastCol: -1})
+}
+
+// tableRegion returns the region of an Excel table, whose first row is its header row
+func tableRegion(t excelize.Table) (sheetRegion, error) {
+	first, last, ok := strings.Cut(strings.ReplaceAll(t.Range, "$", ""), ":")
+	if !ok {
+		return sheetRegion{}, fmt.Errorf("invalid table range %q", t.Range)
+	}
+	firstCol, firstRow, err := excelize.CellNameToCoordinates(first)
+	if err != nil {
+		return sheetRegion{}, err
+	}
+	lastCol, lastRow, err := excelize.CellNameToCoordinates(last)
+	if err != nil {
+		return sheetRegion{}, err
+	}
+	return sheetRegion{HeaderRow: firstRow - 1, LastRow: lastRow - 1, FirstCol: firstCol - 1, LastCol: lastCol - 1, Table: t.Name}, nil
+}
+
+// detectHeaderRow returns the zero-based header row of a worksheet, looking past title
+// banners, blank lines and notes: the first of the top rows with a known URL header,
+// or else the last non-empty row above the first blob URL. Without either the first
+// row is the header.
+func detectHeaderRow(rows [][]string) int {
+	scan := min(len(rows), headerScanRows)
+	for i := 0; i < scan; i++ {
+		if findHeaderColumn(rows[i], urlHeaders) != -1 {
+			return i
+		}
+	}
+
+	isBlobRef := func(v string) bool {
+		_, err := parseBlobRef(v)
+		return err == nil
+	}
+	nonEmpty := func(v string) bool {
+		return strings.TrimSpace(v) != ""
+	}
+	for i := 0; i < scan; i++ {
+		if !slices.ContainsFunc(rows[i], isBlobRef) {
+			continue
+		}
+		for h := i - 1; h >= 0; h-- {
+			if slices.ContainsFunc(rows[h], nonEmpty) {
+				return h
+			}
+		}
+		return 0
+	}
+	return 0
+}
+
+// view returns the rows of the region, keeping their sheet indexes: rows outside it
+// are nil, and cells outside an Excel table's columns are blank
+func (r sheetRegion) view(rows [][]string) [][]string {
+	view := make([][]string, min(len(rows), r.LastRow+1))
+	for i := r.HeaderRow; i < len(view); i++ {
+		if r.Table == "" {
+			view[i] = rows[i]
+			continue
+		}
+		row := make([]string, min(len(rows[i]), r.LastCol+1))
+		if r.FirstCol < len(row) {
+			copy(row[r.FirstCol:], rows[i][r.FirstCol:])
+		}
+		view[i] = row
+	}
+	return view
+}
+
+// resultCol returns the zero-based first result column of the region: right after an
+// Excel table or the last header when those cells are free, and otherwise after the
+// widest row of the region
+func (r sheetRegion) resultCol(rows [][]string) int {
+	col := len(rows[r.HeaderRow])
+	if r.Table != "" {
+		col = r.LastCol + 1
+	}
+
+	widest, inUse := col, false
+	for i := r.HeaderRow; i <= r.LastRow && i < len(rows); i++ {
+		widest = max(widest, len(rows[i]))
+		for c := col; c < min(len(rows[i]), col+len(resultHeaders)); c++ {
+			if strings.TrimSpace(rows[i][c]) != "" {
+				inUse = true
+			}
+		}
+	}
+	if inUse {
+		return widest
+	}
+	return col
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDetectHeaderRow(t *testing.T) {
+	useEndpoints(t, defaultEndpoints(t))
+
+	const blobURL = "https://acct.blob.core.windows.net/c/a.txt"
+	tests := []struct {
+		name string
+		rows [][]string
+		want int
+	}{
+		{
+			name: "header on the first row",
+			rows: [][]string{{"URL", "Tier"}, {blobURL, "Cool"}},
+			want: 0,
+		},
+		{
+			name: "known header below a banner",
+			rows: [][]string{{"Q3 archive restore"}, {}, {"Owner", "Blob URL", "Tier"}, {"ops", blobURL, "Cool"}},
+			want: 2,
+		},
+		{
+			name: "unknown header above the first blob URL",
+			rows: [][]string{{"Restore list"}, {}, {"Location", "Target"}, {}, {blobURL, "Cool"}},
+			want: 2,
+		},
+		{
+			name: "no header",
+			rows: [][]string{{blobURL, "Cool"}},
+			want: 0,
+		},
+		{
+			name: "no blob URLs",
+			rows: [][]string{{"Notes"}, {"nothing to restore"}},
+			want: 0,
+		},
+		{
+			name: "empty sheet",
+			want: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectHeaderRow(tt.rows); got != tt.want {
+				t.Errorf("detectHeaderRow() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectHeaderRowScanLimit(t *testing.T) {
+	useEndpoints(t, defaultEndpoints(t))
+
+	rows := make([][]string, headerScanRows)
+	rows = append(rows, []string{"URL"}, []string{"https://acct.blob.core.windows.net/c/a.txt"})
+	if got := detectHeaderRow(rows); got != 0 {
+		t.Errorf("detectHeaderRow() = %d for a header past the scanned rows, want 0", got)
+	}
+}
+
+func TestResultCol(t *testing.T) {
+	tests := []struct {
+		name   string
+		region sheetRegion
+		rows   [][]string
+		want   int
+	}{
+		{
+			name:   "after the headers",
+			region: sheetRegion{HeaderRow: 0, LastRow: 2, LastCol: -1},
+			rows:   [][]string{{"URL", "Tier"}, {"u1", "Cool"}, {"u2"}},
+			want:   2,
+		},
+		{
+			name:   "after a data row wider than the headers",
+			region: sheetRegion{HeaderRow: 0, LastRow: 2, LastCol: -1},
+			rows:   [][]string{{"URL", "Tier"}, {"u1", "Cool", "", "", "note"}, {"u2"}},
+			want:   5,
+		},
+		{
+			name:   "blank cells past the headers",
+			region: sheetRegion{HeaderRow: 0, LastRow: 1, LastCol: -1},
+			rows:   [][]string{{"URL", "Tier"}, {"u1", "Cool", " "}},
+			want:   2,
+		},
+		{
+			name:   "rows outside the region",
+			region: sheetRegion{HeaderRow: 1, LastRow: 2, LastCol: -1},
+			rows:   [][]string{{"Title", "", "", "", "", "", "banner"}, {"URL", "Tier"}, {"u1", "Cool"}, {"footer", "", "x"}},
+			want:   2,
+		},
+		{
+			name:   "after a table",
+			region: sheetRegion{HeaderRow: 0, LastRow: 1, FirstCol: 1, LastCol: 2, Table: "Blobs"},
+			rows:   [][]string{{"", "URL", "Tier"}, {"", "u1", "Cool"}},
+			want:   3,
+		},
+		{
+			name:   "after cells in use beside a table",
+			region: sheetRegion{HeaderRow: 0, LastRow: 1, FirstCol: 0, LastCol: 1, Table: "Blobs"},
+			rows:   [][]string{{"URL", "Tier", "", "Notes"}, {"u1", "Cool"}},
+			want:   4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.region.resultCol(tt.rows); got != tt.want {
+				t.Errorf("resultCol() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/xuri/excelize/v2"
+)
+
+// rehydrationRecord tracks one blob rehydrating out of Archive and the result columns reporting it
+type rehydrationRecord struct {
+	Account   string `json:"account"`
+	Container string `json:"container"`
+	BlobPath  string `json:"blobPath"`
+	Endpoint  string `json:"endpoint,omitempty"`
+	// VersionID or Snapshot are set when a previous version or a snapshot is rehydrating
+	VersionID string `json:"versionId,omitempty"`
+	Snapshot  string `json:"snapshot,omitempty"`
+	// Version is the Version column value reported for the row
+	Version string `json:"version,omitempty"`
+	Sheet   string `json:"sheet"`
+	// Row and ResultCol are the zero-based row and first result column in the sheet
+	Row         int        `json:"row"`
+	ResultCol   int        `json:"resultCol"`
+	FromTier    string     `json:"fromTier"`
+	ToTier      string     `json:"toTier"`
+	Priority    string     `json:"priority,omitempty"`
+	SubmittedAt time.Time  `json:"submittedAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+	// Attempts and Cost are kept so republished results still show how often the row
+	// was tried and what it was estimated to cost
+	Attempts int           `json:"attempts,omitempty"`
+	Cost     *costEstimate `json:"cost,omitempty"`
+
+	// Copy-based rehydrations are tracked on the destination blob instead of the source
+	Destination  *blobLocation `json:"destination,omitempty"`
+	CopyID       string        `json:"copyId,omitempty"`
+	CopyStatus   string        `json:"copyStatus,omitempty"`
+	CopyProgress string        `json:"copyProgress,omitempty"`
+
+	// ErrorCode and Error are set when polling failed permanently, for instance because
+	// the blob was deleted; the record is then completed without the rehydration
+	ErrorCode string `json:"errorCode,omitempty"`
+	Error     string `json:"error,omitempty"`
+
+	// Expanded marks a blob matched by a prefix row of a manifest without an expansion
+	// sheet. Row and ResultCol then address the prefix row, whose Details count the
+	// finished rehydrations of all its blobs after PrefixDetails.
+	Expanded      bool   `json:"expanded,omitempty"`
+	PrefixDetails string `json:"prefixDetails,omitempty"`
+}
+
+// source returns the location of the blob, version or snapshot being rehydrated
+func (r *rehydrationRecord) source() blobLocation {
+	return blobLocation{
+		Account:   r.Account,
+		Container: r.Container,
+		Path:      r.BlobPath,
+		Endpoint:  r.Endpoint,
+		VersionID: r.VersionID,
+		Snapshot:  r.Snapshot,
+	}
+}
+
+// rehydrationTracker groups the rehydrations reported in one processed workbook
+type rehydrationTracker struct {
+	InputURL        string              `json:"inputUrl"`
+	OutputAccount   string              `json:"outputAccount"`
+	OutputContainer string              `json:"outputContainer"`
+	OutputFile      string              `json:"outputFile"`
+	Rehydrations    []rehydrationRecord `json:"rehydrations"`
+}
+
+// stateDir returns the directory used for autotier's local state
+func stateDir() string {
+	if dir := os.Getenv("STATE_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "autotier")
+}
+
+// rehydrationTrackerDir returns the directory holding one tracker file per processed workbook
+func rehydrationTrackerDir() string {
+	return filepath.Join(stateDir(), "rehydrations")
+}
+
+// writeJSONFile atomically replaces path with the JSON encoding of v
+func writeJSONFile(path string, v interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// outputLocks serialises the job workers and the rehydration checker on each output
+// file, so neither uploads an output or saves a tracker over the other's
+var outputLocks = &fileLocks{locks: make(map[string]*fileLock)}
+
+// fileLocks holds a mutex per output file name while it is in use
+type fileLocks struct {
+	mu    sync.Mutex
+	locks map[string]*fileLock
+}
+
+// fileLock is the mutex of one output file and the number of holders and waiters
+type fileLock struct {
+	sync.Mutex
+	users int
+}
+
+// lock blocks until the output file name is free and returns the function releasing it
+func (l *fileLocks) lock(name string) func() {
+	l.mu.Lock()
+	fl, ok := l.locks[name]
+	if !ok {
+		fl = &fileLock{}
+		l.locks[name] = fl
+	}
+	fl.users++
+	l.mu.Unlock()
+
+	fl.Lock()
+	return func() {
+		fl.Unlock()
+		l.mu.Lock()
+		if fl.users--; fl.users == 0 {
+			delete(l.locks, name)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// rehydrationTrackerPath returns the file the tracker of an output file is saved to
+func rehydrationTrackerPath(outputFile string) string {
+	return filepath.Join(rehydrationTrackerDir(), outputFile+".json")
+}
+
+// saveRehydrationTracker persists a tracker, keyed by its output file name. The
+// caller holds the output file's lock.
+func saveRehydrationTracker(t *rehydrationTracker) error {
+	return writeJSONFile(rehydrationTrackerPath(t.OutputFile), t)
+}
+
+// removeRehydrationTracker drops the tracker of an output file, if any. The caller
+// holds the output file's lock.
+func removeRehydrationTracker(outputFile string) error {
+	if err := os.Remove(rehydrationTrackerPath(outputFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// readRehydrationTracker reads the tracker persisted at path
+func readRehydrationTracker(path string) (*rehydrationTracker, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t rehydrationTracker
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return &t, nil
+}
+
+// loadRehydrationTrackers reads every persisted tracker, keyed by file path
+func loadRehydrationTrackers() (map[string]*rehydrationTracker, error) {
+	entries, err := os.ReadDir(rehydrationTrackerDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	trackers := make(map[string]*rehydrationTracker)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		path := filepath.Join(rehydrationTrackerDir(), entry.Name())
+		t, err := readRehydrationTracker(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Ignoring unreadable rehydration tracker %s: %v", path, err)
+			continue
+		}
+		trackers[path] = t
+	}
+	return trackers, nil
+}
+
+// runRehydrationChecker periodically re-polls tracked rehydrations until they complete
+func runRehydrationChecker(interval time.Duration) {
+	log.Printf("Rehydration checker running every %s", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := checkRehydrations(context.Background()); err != nil {
+			log.Printf("Rehydration check failed: %v", err)
+		}
+	}
+}
+
+// checkRehydrations polls every pending blob and republishes the processed
+// workbooks whose rehydrations have progressed since the last check
+func checkRehydrations(ctx context.Context) error {
+	trackers, err := loadRehydrationTrackers()
+	if err != nil {
+		return fmt.Errorf("failed to load rehydration trackers: %w", err)
+	}
+	if len(trackers) == 0 {
+		return nil
+	}
+
+	for path, t := range trackers {
+		checkTracker(ctx, path, t.OutputFile)
+	}
+	return nil
+}
+
+// checkTracker polls the rehydrations tracked at path and republishes their output
+// file. It holds the output file's lock throughout and reads the tracker again under
+// it, so a job that published a new output under the same name in the meantime is
+// not overwritten.
+func checkTracker(ctx context.Context, path, outputFile string) {
+	unlock := outputLocks.lock(outputFile)
+	defer unlock()
+
+	t, err := readRehydrationTracker(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Ignoring unreadable rehydration tracker %s: %v", path, err)
+		return
+	}
+
+	updated, remaining := pollRehydrations(ctx, t)
+	if len(updated) == 0 {
+		return
+	}
+
+	if err := publishRehydrationUpdates(ctx, t, updated); err != nil {
+		log.Printf("Failed to publish rehydration updates for %s: %v", t.OutputFile, err)
+		return
+	}
+
+	if remaining == 0 {
+		log.Printf("✅ All rehydrations completed for %s", t.OutputFile)
+		if err := os.Remove(path); err != nil {
+			log.Printf("Failed to remove rehydration tracker %s: %v", path, err)
+		}
+		return
+	}
+
+	if err := writeJSONFile(path, t); err != nil {
+		log.Printf("Failed to save rehydration tracker %s: %v", path, err)
+	}
+}
+
+// pollRehydrations refreshes the records of t that have not completed yet. It returns
+// the records whose reported status changed and the number still pending.
+func pollRehydrations(ctx context.Context, t *rehydrationTracker) ([]rehydrationRecord, int) {
+	var updated []rehydrationRecord
+	remaining := 0
+
+	for i := range t.Rehydrations {
+		r := &t.Rehydrations[i]
+		if r.CompletedAt != nil {
+			continue
+		}
+
+		target := r.source()
+		if r.Destination != nil {
+			target = *r.Destination
+		}
+
+		props, err := storage.GetProperties(ctx, target)
+		if err != nil && !pollFailedPermanently(ctx, err) {
+			log.Printf("Failed to poll %s: %v", target, err)
+			remaining++
+			continue
+		}
+		if err != nil {
+			completedAt := time.Now().UTC()
+			r.CompletedAt = &completedAt
+			code, message := classifyError(err)
+			r.ErrorCode, r.Error = code, "Poll: "+message
+			log.Printf("Stopped tracking %s: %s %s", target, code, message)
+			updated = append(updated, *r)
+			continue
+		}
+
+		changed, done := false, false
+		completedAt := time.Now().UTC()
+		if r.Destination != nil {
+			copyStatus := string(blob.CopyStatusTypePending)
+			if props.CopyStatus != "" {
+				copyStatus = string(props.CopyStatus)
+			}
+			progress := props.CopyProgress
+			changed = copyStatus != r.CopyStatus || progress != r.CopyProgress
+			r.CopyStatus, r.CopyProgress = copyStatus, progress
+			done = copyStatus != string(blob.CopyStatusTypePending)
+			if props.CopyCompletionTime != nil {
+				completedAt = props.CopyCompletionTime.UTC()
+			}
+		} else {
+			done = props.AccessTier != blob.AccessTierArchive && props.ArchiveStatus == ""
+			changed = done
+			if props.AccessTierChangeTime != nil {
+				completedAt = props.AccessTierChangeTime.UTC()
+			}
+		}
+
+		if done {
+			r.CompletedAt = &completedAt
+			log.Printf("Rehydration completed: %s → %s at %s", target, r.ToTier, completedAt.Format(time.RFC3339))
+		} else {
+			remaining++
+		}
+		if changed {
+			updated = append(updated, *r)
+		}
+	}
+
+	return updated, remaining
+}
+
+// pollFailedPermanently reports whether a poll error will not go away on a later
+// check: the service answered with an error that is not transient, such as a deleted
+// blob or revoked access. Credential and network failures are retried next check.
+func pollFailedPermanently(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	_, _, ok := errorResponse(err)
+	return ok && !isTransient(err)
+}
+
+// publishRehydrationUpdates rewrites the result columns of updated rehydrations in
+// the processed workbook and uploads it again
+func publishRehydrationUpdates(ctx context.Context, t *rehydrationTracker, updated []rehydrationRecord) error {
+	outputURL := blobLocation{Account: t.OutputAccount, Container: t.OutputContainer, Path: t.OutputFile}.URL()
+	output, err := downloadBlob(ctx, outputURL)
+	if err != nil {
+		return err
+	}
+
+	m, err := loadManifest(t.OutputFile, output.Data)
+	if err != nil {
+		return err
+	}
+	defer m.File.Close()
+
+	for _, r := range updated {
+		if r.Expanded {
+			if err := writePrefixRehydrations(m.File, t, r); err != nil {
+				return fmt.Errorf("failed to update results of %s row %d: %w", r.Sheet, r.Row+1, err)
+			}
+			continue
+		}
+		if err := writeRowResult(m.File, r.Sheet, r.Row, r.ResultCol, rehydrationResult(r)); err != nil {
+			return fmt.Errorf("failed to update results of %s row %d: %w", r.Sheet, r.Row+1, err)
+		}
+	}
+
+	outputBuffer, err := m.encode()
+	if err != nil {
+		return err
+	}
+
+	return uploadToOutputContainer(ctx, t.OutputAccount, t.OutputContainer, t.OutputFile, m.contentType(), outputBuffer)
+}
+
+// writePrefixRehydrations rewrites the Details of the prefix row r belongs to with
+// the number of the row's tracked rehydrations that have finished
+func writePrefixRehydrations(f *excelize.File, t *rehydrationTracker, r rehydrationRecord) error {
+	done, total := 0, 0
+	for _, other := range t.Rehydrations {
+		if other.Expanded && other.Sheet == r.Sheet && other.Row == r.Row {
+			total++
+			if other.CompletedAt != nil {
+				done++
+			}
+		}
+	}
+
+	cell, err := excelize.CoordinatesToCellName(r.ResultCol+slices.Index(resultHeaders, "Details")+1, r.Row+1)
+	if err != nil {
+		return err
+	}
+	return f.SetCellValue(r.Sheet, cell, prefixRehydrationDetails(r.PrefixDetails, done, total))
+}
+
+// prefixRehydrationDetails appends the progress of a prefix row's tracked
+// rehydrations to its Details
+func prefixRehydrationDetails(details string, done, total int) string {
+	return fmt.Sprintf("%s; %d of %d rehydrations finished", details, done, total)
+}
+
+// rehydrationResult returns the result columns of a tracked rehydration after a poll
+func rehydrationResult(r rehydrationRecord) rowResult {
+	result := rowResult{
+		PreviousTier: r.FromTier,
+		NewTier:      r.ToTier,
+		Priority:     r.Priority,
+		Timestamp:    time.Now().UTC(),
+		Attempts:     r.Attempts,
+		ResolvedURL:  r.source().URL(),
+		Version:      r.Version,
+		Cost:         r.Cost,
+	}
+	if r.CompletedAt != nil {
+		result.Timestamp = *r.CompletedAt
+	}
+
+	if r.ErrorCode != "" {
+		result.Code, result.NewTier, result.Priority, result.Error, result.Cost = r.ErrorCode, "", "", r.Error, nil
+		return result
+	}
+
+	if r.Destination != nil {
+		result.Code = copyResultCode(r.CopyStatus)
+		result.Details = copyStatusText(blob.AccessTier(r.FromTier), blob.AccessTier(r.ToTier), *r.Destination, r.CopyID, r.CopyStatus, r.CopyProgress)
+		if result.Code == resultCopyFailed {
+			result.Error = fmt.Sprintf("Copy %s %s", r.CopyID, strings.ToLower(r.CopyStatus))
+		}
+		return result
+	}
+
+	result.Code = resultRehydrated
+	completedAt := r.CompletedAt.Format("2006-01-02 15:04 UTC")
+	if r.Priority != "" {
+		result.Details = fmt.Sprintf("Rehydrated: Archive → %s (%s priority, completed %s)", r.ToTier, r.Priority, completedAt)
+	} else {
+		result.Details = fmt.Sprintf("Rehydrated: Archive → %s (completed %s)", r.ToTier, completedAt)
+	}
+	return result
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRehydrationTrackerRoundTrip(t *testing.T) {
+	t.Setenv("STATE_DIR", t.TempDir())
+
+	saved := &rehydrationTracker{
+		InputURL:        "https://acct.blob.core.windows.net/in/manifest.xlsx",
+		OutputAccount:   "out",
+		OutputContainer: "processed",
+		OutputFile:      "manifest_processed.xlsx",
+		Rehydrations: []rehydrationRecord{
+			{Account: "acct", Container: "c", BlobPath: "a.txt", ToTier: "Cool", Priority: "High"},
+		},
+	}
+	if err := saveRehydrationTracker(saved); err != nil {
+		t.Fatal(err)
+	}
+	// Unreadable files are skipped rather than failing every check
+	if err := os.WriteFile(filepath.Join(rehydrationTrackerDir(), "broken.json"), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	trackers, err := loadRehydrationTrackers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trackers) != 1 {
+		t.Fatalf("loaded %d trackers, want 1", len(trackers))
+	}
+	loaded := trackers[rehydrationTrackerPath("manifest_processed.xlsx")]
+	if loaded == nil {
+		t.Fatalf("tracker not keyed by its path: %v", trackers)
+	}
+	if loaded.InputURL != saved.InputURL || loaded.OutputFile != saved.OutputFile || len(loaded.Rehydrations) != 1 {
+		t.Errorf("loaded %+v, want %+v", loaded, saved)
+	}
+	if r := loaded.Rehydrations[0]; r.BlobPath != "a.txt" || r.Priority != "High" {
+		t.Errorf("loaded rehydration %+v", r)
+	}
+}
+
+func TestLoadRehydrationTrackersWithoutState(t *testing.T) {
+	t.Setenv("STATE_DIR", filepath.Join(t.TempDir(), "missing"))
+
+	trackers, err := loadRehydrationTrackers()
+	if err != nil || len(trackers) != 0 {
+		t.Errorf("loadRehydrationTrackers() = %v, %v; want no trackers", trackers, err)
+	}
+}
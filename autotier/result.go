@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/xuri/excelize/v2"
+)
+
+// Result codes written to the Result column
+const (
+	resultChanged     = "CHANGED"
+	resultWouldChange = "WOULD_CHANGE"
+	resultPending     = "PENDING"
+	resultRehydrated  = "REHYDRATED"
+	resultCopying     = "COPYING"
+	resultCopied      = "COPIED"
+	resultCopyFailed  = "COPY_FAILED"
+	resultExpanded    = "EXPANDED"
+	resultSkipped     = "SKIPPED"
+	resultInvalidRow  = "INVALID_ROW"
+	resultNotFound    = "NOT_FOUND"
+	resultForbidden   = "FORBIDDEN"
+	resultThrottled   = "THROTTLED"
+	resultConflict    = "CONFLICT"
+	resultBadRequest  = "BAD_REQUEST"
+	resultServerError = "SERVER_ERROR"
+	resultTimeout     = "TIMEOUT"
+	resultError       = "ERROR"
+)
+
+// resultHeaders are the columns appended to each processed sheet, in order
+var resultHeaders = []string{"Result", "Previous Tier", "New Tier", "Archive Status", "Rehydrate Priority", "Timestamp", "Attempts", "Error", "Details", "Resolved URL", "Version",
+	"Size (GB)", "Est. Read", "Est. Rehydration", "Est. Early Deletion", costTotalHeader}
+
+// numericResultHeaders are the result columns holding numbers
+var numericResultHeaders = []string{"Attempts", "Size (GB)", "Est. Read", "Est. Rehydration", "Est. Early Deletion", costTotalHeader}
+
+// costTotalHeader is the result column holding a row's estimated total cost, whose
+// header carries the sheet's cost totals
+const costTotalHeader = "Est. Total"
+
+// rowResult is the content of a row's result columns
+type rowResult struct {
+	Code          string
+	PreviousTier  string
+	NewTier       string
+	ArchiveStatus string
+	// Priority is the rehydrate priority of a blob leaving Archive
+	Priority  string
+	Timestamp time.Time
+	// Attempts is the number of times the row was tried, including retries
+	Attempts int
+	// Error is a short description of a failure, without the SDK's response dump
+	Error string
+	// Details is the human readable summary of what happened to the blob
+	Details string
+	// ResolvedURL is the blob URL the row's location resolved to, which differs from
+	// the manifest value for Data Lake (dfs, abfss://, wasbs://) locations
+	ResolvedURL string
+	// Version is the version or snapshot acted on, see versionLabel
+	Version string
+	// Cost is the estimated cost of the row's action; nil leaves the cost columns empty
+	Cost *costEstimate
+}
+
+// writeResultHeaders writes resultHeaders into the zero-based header row, starting at the zero-based column col
+func writeResultHeaders(f *excelize.File, sheetName string, row, col int) error {
+	cell, err := excelize.CoordinatesToCellName(col+1, row+1)
+	if err != nil {
+		return err
+	}
+
+	headers := make([]interface{}, len(resultHeaders))
+	for i, h := range resultHeaders {
+		headers[i] = h
+	}
+	return f.SetSheetRow(sheetName, cell, &headers)
+}
+
+// writeRowResult writes r into the zero-based row's result columns, starting at the zero-based column col
+func writeRowResult(f *excelize.File, sheetName string, row, col int, r rowResult) error {
+	cell, err := excelize.CoordinatesToCellName(col+1, row+1)
+	if err != nil {
+		return err
+	}
+
+	var timestamp string
+	if !r.Timestamp.IsZero() {
+		timestamp = r.Timestamp.UTC().Format(time.RFC3339)
+	}
+	var attempts interface{} = ""
+	if r.Attempts > 0 {
+		attempts = r.Attempts
+	}
+	values := []interface{}{r.Code, r.PreviousTier, r.NewTier, r.ArchiveStatus, r.Priority, timestamp, attempts, r.Error, r.Details, r.ResolvedURL, r.Version}
+	if r.Cost != nil {
+		values = append(values, roundCost(r.Cost.SizeGB), roundCost(r.Cost.Read), roundCost(r.Cost.Rehydration),
+			roundCost(r.Cost.EarlyDeletion), roundCost(r.Cost.Total))
+	} else {
+		values = append(values, "", "", "", "", "")
+	}
+	return f.SetSheetRow(sheetName, cell, &values)
+}
+
+// addCostNote notes the estimated cost totals on the cost total header of the result
+// columns starting at the zero-based header row and column col
+func addCostNote(f *excelize.File, sheetName string, row, col int, cost costEstimate) {
+	cell, err := excelize.CoordinatesToCellName(col+slices.Index(resultHeaders, costTotalHeader)+1, row+1)
+	if err == nil {
+		err = f.AddComment(sheetName, excelize.Comment{Cell: cell, Author: "autotier", Text: "Estimated cost " + cost.String()})
+	}
+	if err != nil {
+		log.Printf("Failed to add cost note to sheet %s: %v", sheetName, err)
+	}
+}
+
+// classifyError maps an error to a result code and a short message. Azure errors are
+// classified by their error code, falling back to the HTTP status.
+func classifyError(err error) (string, string) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return resultTimeout, "timed out"
+	}
+	if errors.Is(err, context.Canceled) {
+		return resultError, "cancelled"
+	}
+
+	errorCode, statusCode, ok := errorResponse(err)
+	if !ok {
+		// Keep the first line; credential and transport errors can span many
+		message, _, _ := strings.Cut(err.Error(), "\n")
+		return resultError, message
+	}
+
+	message := fmt.Sprintf("%s (HTTP %d)", errorCode, statusCode)
+	if errorCode == "" {
+		message = fmt.Sprintf("HTTP %d %s", statusCode, http.StatusText(statusCode))
+	}
+
+	switch bloberror.Code(errorCode) {
+	case bloberror.BlobNotFound, bloberror.ContainerNotFound, bloberror.ResourceNotFound:
+		return resultNotFound, message
+	case bloberror.AuthenticationFailed, bloberror.AuthorizationFailure, bloberror.AuthorizationPermissionMismatch,
+		bloberror.AuthorizationProtocolMismatch, bloberror.AuthorizationResourceTypeMismatch,
+		bloberror.AuthorizationServiceMismatch, bloberror.AuthorizationSourceIPMismatch,
+		bloberror.InsufficientAccountPermissions, bloberror.AccountIsDisabled:
+		return resultForbidden, message
+	case bloberror.ServerBusy:
+		return resultThrottled, message
+	case bloberror.OperationTimedOut:
+		return resultTimeout, message
+	case bloberror.BlobBeingRehydrated, bloberror.BlobArchived, bloberror.LeaseIDMissing,
+		bloberror.LeaseIDMismatchWithBlobOperation, bloberror.BlobImmutableDueToPolicy,
+		bloberror.PendingCopyOperation:
+		return resultConflict, message
+	}
+
+	switch {
+	case statusCode == http.StatusNotFound:
+		return resultNotFound, message
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return resultForbidden, message
+	case statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable:
+		return resultThrottled, message
+	case statusCode == http.StatusConflict || statusCode == http.StatusPreconditionFailed:
+		return resultConflict, message
+	case statusCode >= 500:
+		return resultServerError, message
+	case statusCode >= 400:
+		return resultBadRequest, message
+	default:
+		return resultError, message
+	}
+}
+
+// errorResponse returns the storage error code and HTTP status of an error response
+// from Azure or another storage backend. ok is false for any other error.
+func errorResponse(err error) (errorCode string, statusCode int, ok bool) {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.ErrorCode, respErr.StatusCode, true
+	}
+	var storageErr *storageError
+	if errors.As(err, &storageErr) {
+		return storageErr.Code, storageErr.StatusCode, true
+	}
+	return "", 0, false
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantCode    string
+		wantMessage string
+	}{
+		{"deadline", fmt.Errorf("set tier: %w", context.DeadlineExceeded), resultTimeout, "timed out"},
+		{"cancelled", context.Canceled, resultError, "cancelled"},
+		{"plain error keeps first line", errors.New("no credential\nsee docs"), resultError, "no credential"},
+		{"blob not found", &azcore.ResponseError{ErrorCode: "BlobNotFound", StatusCode: http.StatusNotFound}, resultNotFound, "BlobNotFound (HTTP 404)"},
+		{"permission mismatch", &azcore.ResponseError{ErrorCode: "AuthorizationPermissionMismatch", StatusCode: http.StatusForbidden}, resultForbidden, "AuthorizationPermissionMismatch (HTTP 403)"},
+		{"server busy", &azcore.ResponseError{ErrorCode: "ServerBusy", StatusCode: http.StatusServiceUnavailable}, resultThrottled, "ServerBusy (HTTP 503)"},
+		{"being rehydrated", &azcore.ResponseError{ErrorCode: "BlobBeingRehydrated", StatusCode: http.StatusConflict}, resultConflict, "BlobBeingRehydrated (HTTP 409)"},
+		{"status only", &azcore.ResponseError{StatusCode: http.StatusTooManyRequests}, resultThrottled, "HTTP 429 Too Many Requests"},
+		{"unknown server code", &azcore.ResponseError{ErrorCode: "Whatever", StatusCode: http.StatusBadGateway}, resultServerError, "Whatever (HTTP 502)"},
+		{"unknown client code", &azcore.ResponseError{ErrorCode: "InvalidHeaderValue", StatusCode: http.StatusBadRequest}, resultBadRequest, "InvalidHeaderValue (HTTP 400)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, message := classifyError(tt.err)
+			if code != tt.wantCode || message != tt.wantMessage {
+				t.Errorf("classifyError() = %q, %q, want %q, %q", code, message, tt.wantCode, tt.wantMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net"
+	"time"
+)
+
+// retryPolicy controls how rows failing with transient errors are retried
+type retryPolicy struct {
+	// MaxAttempts bounds the attempts per row, including the first
+	MaxAttempts int
+	// BaseDelay is the backoff before the first retry, doubling up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Deadline is the end of the job's retry budget; no retry is started after it
+	Deadline time.Time
+}
+
+// loadRetryPolicy reads the retry settings from the environment. The job's retry
+// budget starts now.
+func loadRetryPolicy() (retryPolicy, error) {
+	var p retryPolicy
+	var err error
+	if p.MaxAttempts, err = envInt("ROW_MAX_ATTEMPTS", 5); err != nil {
+		return p, err
+	}
+	if p.BaseDelay, err = envDuration("RETRY_BASE_DELAY", time.Second); err != nil {
+		return p, err
+	}
+	if p.MaxDelay, err = envDuration("RETRY_MAX_DELAY", 30*time.Second); err != nil {
+		return p, err
+	}
+	budget, err := envDuration("JOB_RETRY_BUDGET", 15*time.Minute)
+	if err != nil {
+		return p, err
+	}
+	p.Deadline = time.Now().Add(budget)
+	return p, nil
+}
+
+// do calls fn until it succeeds, fails permanently, runs out of attempts, or the
+// next backoff would end past the deadline or ctx. It returns fn's last result and
+// the number of attempts made.
+func (p retryPolicy) do(ctx context.Context, name string, fn func() (tierResult, error)) (tierResult, int, error) {
+	for attempt := 1; ; attempt++ {
+		result, err := fn()
+		if err == nil || !isTransient(err) || attempt >= p.MaxAttempts {
+			return result, attempt, err
+		}
+
+		delay := p.backoff(attempt)
+		if time.Now().Add(delay).After(p.Deadline) {
+			log.Printf("%s: retry budget exhausted after %d attempts", name, attempt)
+			return result, attempt, err
+		}
+
+		code, message := classifyError(err)
+		log.Printf("%s: attempt %d failed with %s %s, retrying in %s", name, attempt, code, message, delay.Round(time.Millisecond))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return result, attempt, err
+		}
+	}
+}
+
+// backoff returns the delay before retrying after the given attempt: exponential in
+// the attempt number, capped at MaxDelay, with the upper half jittered
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.MaxDelay)
+
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + rand.N(half)
+}
+
+// isTransient reports whether err may succeed on retry: throttling, timeouts,
+// server errors, network failures and unanswered batch sub-requests. Network errors
+// that repeat on every try, such as a host that does not resolve or a certificate that
+// does not verify, are permanent.
+func isTransient(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, errMissingSubResponse) {
+		return true
+	}
+
+	if _, _, ok := errorResponse(err); ok {
+		code, _ := classifyError(err)
+		return code == resultThrottled || code == resultTimeout || code == resultServerError
+	}
+
+	if isPermanentNetError(err) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// isPermanentNetError reports whether err is a network error retrying cannot fix: an
+// unknown host, which is usually a mistyped storage account, an invalid address or a
+// certificate that does not verify
+func isPermanentNetError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return true
+	}
+	var addrErr *net.AddrError
+	var certErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	return errors.As(err, &addrErr) || errors.As(err, &certErr) || errors.As(err, &authorityErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &invalidErr)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+func TestIsTransient(t *testing.T) {
+	dial := func(err error) error {
+		return &url.Error{Op: "Get", URL: "https://acct.blob.core.windows.net/c/a.txt", Err: &net.OpError{Op: "dial", Net: "tcp", Err: err}}
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection reset", dial(errors.New("connection reset by peer")), true},
+		{"DNS timeout", dial(&net.DNSError{Err: "i/o timeout", Name: "acct.blob.core.windows.net", IsTimeout: true}), true},
+		{"deadline", fmt.Errorf("get properties: %w", context.DeadlineExceeded), true},
+		{"unanswered batch change", errMissingSubResponse, true},
+		{"server busy", &azcore.ResponseError{ErrorCode: "ServerBusy", StatusCode: http.StatusServiceUnavailable}, true},
+		{"internal error", &azcore.ResponseError{StatusCode: http.StatusInternalServerError}, true},
+		{"blob not found", &azcore.ResponseError{ErrorCode: "BlobNotFound", StatusCode: http.StatusNotFound}, false},
+		{"unknown host", dial(&net.DNSError{Err: "no such host", Name: "acct.blob.core.windows.net", IsNotFound: true}), false},
+		{"untrusted certificate", &url.Error{Op: "Get", URL: "https://acct.blob.core.windows.net", Err: x509.UnknownAuthorityError{}}, false},
+		{"cancelled", context.Canceled, false},
+		{"other", errors.New("invalid tier"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransient(tt.err); got != tt.want {
+				t.Errorf("isTransient(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+)
+
+// storage is the backend all blob access goes through, set up in main
+var storage storageBackend
+
+// storageBackend is the blob storage operations autotier needs. azureStorage talks to
+// Azure Storage; memoryStorage simulates it, tiers and rehydration included, so whole
+// manifests can be processed without an account.
+type storageBackend interface {
+	// GetProperties returns the properties of a blob, or of the version or snapshot loc names
+	GetProperties(ctx context.Context, loc blobLocation) (blobProperties, error)
+	// SetTier changes the tier of a blob, or of the version or snapshot loc names.
+	// priority applies when rehydrating out of Archive and may be empty.
+	SetTier(ctx context.Context, loc blobLocation, tier blob.AccessTier, priority blob.RehydratePriority) error
+	// SetTierBatch applies up to maxBatchSize tier changes to blobs in one container as
+	// a single request. It returns one error per change, in order, or an error when the
+	// batch as a whole failed.
+	SetTierBatch(ctx context.Context, changes []tierChange) ([]error, error)
+	// StartCopy starts copying source to dest at the given tier and rehydrate priority
+	StartCopy(ctx context.Context, source, dest blobLocation, tier blob.AccessTier, priority blob.RehydratePriority) (copyInfo, error)
+	// Download reads a blob into memory with its metadata and ETag
+	Download(ctx context.Context, loc blobLocation) (*downloadedBlob, error)
+	// Upload writes a block blob, creating its container when it does not exist
+	Upload(ctx context.Context, loc blobLocation, data []byte, contentType string) error
+	// List returns the blobs in loc's container whose path starts with loc.Path, in
+	// name order. It stops after limit blobs when limit is positive.
+	List(ctx context.Context, loc blobLocation, limit int) ([]blobItem, error)
+}
+
+// blobProperties are the blob properties autotier reads
+type blobProperties struct {
+	// AccessTier is empty for blobs without a tier, such as page blobs
+	AccessTier           blob.AccessTier
+	ArchiveStatus        string
+	RehydratePriority    blob.RehydratePriority
+	AccessTierChangeTime *time.Time
+	ETag                 string
+	// VersionID is the version read: the current version of a base blob when the
+	// account keeps versions, or the version the location named
+	VersionID     string
+	ContentLength int64
+	LastModified  time.Time
+
+	// Copy properties describe the last copy into the blob, if any
+	CopyID             string
+	CopyStatus         blob.CopyStatusType
+	CopyProgress       string
+	CopyCompletionTime *time.Time
+}
+
+// blobItem is a blob returned by List
+type blobItem struct {
+	Location   blobLocation
+	Properties blobProperties
+}
+
+// copyInfo identifies a started copy and its status
+type copyInfo struct {
+	ID     string
+	Status blob.CopyStatusType
+}
+
+// downloadedBlob is the content of a blob along with its metadata and ETag
+type downloadedBlob struct {
+	Data     []byte
+	Metadata map[string]*string
+	ETag     string
+}
+
+// storageError is an error response from a storage backend other than Azure, carrying
+// the error code and HTTP status Azure Storage would have responded with
+type storageError struct {
+	Code       string
+	StatusCode int
+}
+
+// Error implements the error interface
+func (e *storageError) Error() string {
+	return fmt.Sprintf("%s (HTTP %d)", e.Code, e.StatusCode)
+}
+
+// newStorageBackend creates the backend named by STORAGE_BACKEND: "azure" (the
+// default) or "memory", optionally seeded from the file named by MEMORY_STORAGE_SEED
+func newStorageBackend() (storageBackend, error) {
+	switch backend := strings.ToLower(os.Getenv("STORAGE_BACKEND")); backend {
+	case "", "azure":
+		return newAzureStorage()
+	case "memory":
+		delay, err := envDuration("MEMORY_REHYDRATION_DELAY", time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		m := newMemoryStorage(delay)
+		if seed := os.Getenv("MEMORY_STORAGE_SEED"); seed != "" {
+			if err := m.seedFile(seed); err != nil {
+				return nil, fmt.Errorf("failed to seed memory storage: %w", err)
+			}
+		}
+		log.Printf("⚠️ Using in-memory storage; nothing is read from or written to Azure")
+		return m, nil
+	default:
+		return nil, fmt.Errorf("invalid STORAGE_BACKEND %q: expected azure or memory", backend)
+	}
+}
+
+// downloadBlob reads the full content of the blob at blobURL into memory, along with its metadata
+func downloadBlob(ctx context.Context, blobURL string) (*downloadedBlob, error) {
+	loc, err := parseBlobRef(blobURL)
+	if err != nil {
+		return nil, err
+	}
+	return storage.Download(ctx, loc)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// maxSheetHyperlinks is the most hyperlinks Excel opens in one worksheet
+const maxSheetHyperlinks = 65530
+
+// resultStyle is the colouring of Result cells holding one of Codes
+type resultStyle struct {
+	Font  string
+	Fill  string
+	Codes []string
+}
+
+// resultStyles colour Result cells green for changes, amber for work still running in
+// Azure, grey for skipped rows and red for errors, matching Excel's Good, Neutral and
+// Bad cell styles
+var resultStyles = []resultStyle{
+	{Font: "006100", Fill: "C6EFCE", Codes: []string{resultChanged, resultWouldChange, resultRehydrated, resultCopied, resultExpanded}},
+	{Font: "9C5700", Fill: "FFEB9C", Codes: []string{resultPending, resultCopying}},
+	{Font: "595959", Fill: "EDEDED", Codes: []string{resultSkipped}},
+	{Font: "9C0006", Fill: "FFC7CE", Codes: []string{
+		resultCopyFailed, resultInvalidRow, resultNotFound, resultForbidden, resultThrottled,
+		resultConflict, resultBadRequest, resultServerError, resultTimeout, resultError,
+	}},
+}
+
+// resultColWidths are the widths of the result columns, in resultHeaders order
+var resultColWidths = []float64{14, 14, 12, 22, 18, 22, 10, 40, 50, 50, 30, 12, 12, 16, 20, 12}
+
+// styleResultSheet makes a worksheet with result columns at the zero-based col
+// reviewable in Excel: the result headers on the zero-based headerRow are set apart,
+// Result cells are coloured by code, the header row is frozen and an autofilter covers
+// the table down to the first rows rows. Only the result columns are formatted; the
+// colours are conditional formats, so they follow the Result cells when the
+// rehydration checker rewrites them later. A freeze or autofilter already in the sheet
+// is kept.
+func styleResultSheet(f *excelize.File, sheetName string, headerRow, col, rows int) error {
+	first, err := excelize.CoordinatesToCellName(col+1, headerRow+1)
+	if err != nil {
+		return err
+	}
+	last, err := excelize.CoordinatesToCellName(col+len(resultHeaders), headerRow+1)
+	if err != nil {
+		return err
+	}
+	headerStyle, err := newHeaderStyle(f)
+	if err != nil {
+		return err
+	}
+	if err := f.SetCellStyle(sheetName, first, last, headerStyle); err != nil {
+		return err
+	}
+	for i, width := range resultColWidths {
+		name, err := excelize.ColumnNumberToName(col + i + 1)
+		if err != nil {
+			return err
+		}
+		if err := f.SetColWidth(sheetName, name, name, width); err != nil {
+			return err
+		}
+	}
+
+	if rows > headerRow+1 {
+		if err := addResultFormats(f, sheetName, headerRow+1, col, rows); err != nil {
+			return err
+		}
+	}
+
+	panes, err := f.GetPanes(sheetName)
+	if err != nil {
+		return err
+	}
+	if !panes.Freeze {
+		topLeft, err := excelize.CoordinatesToCellName(1, headerRow+2)
+		if err != nil {
+			return err
+		}
+		err = f.SetPanes(sheetName, &excelize.Panes{
+			Freeze: true, YSplit: headerRow + 1, TopLeftCell: topLeft, ActivePane: "bottomLeft",
+			Selection: []excelize.Selection{{SQRef: topLeft, ActiveCell: topLeft, Pane: "bottomLeft"}},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// A sheet holding an Excel table or a filter of its own keeps it; AutoFilter
+	// refuses ranges overlapping a table
+	if hasAutoFilter(f, sheetName) {
+		return nil
+	}
+	topLeft, err := excelize.CoordinatesToCellName(1, headerRow+1)
+	if err != nil {
+		return err
+	}
+	bottomRight, err := excelize.CoordinatesToCellName(col+len(resultHeaders), max(rows, headerRow+1))
+	if err != nil {
+		return err
+	}
+	// AutoFilter replaces the sheet's properties, tab colour included, so they are
+	// put back afterwards
+	props, err := f.GetSheetProps(sheetName)
+	if err != nil {
+		return err
+	}
+	if err := f.AutoFilter(sheetName, topLeft+":"+bottomRight, nil); err != nil {
+		log.Printf("Not adding an autofilter to sheet %s: %v", sheetName, err)
+		return nil
+	}
+	return f.SetSheetProps(sheetName, &props)
+}
+
+// newHeaderStyle adds the style of the headers autotier writes to f
+func newHeaderStyle(f *excelize.File) (int, error) {
+	return f.NewStyle(&excelize.Style{
+		Font:      &excelize.Font{Bold: true},
+		Fill:      excelize.Fill{Type: "pattern", Color: []string{"D9E1F2"}, Pattern: 1},
+		Alignment: &excelize.Alignment{Vertical: "top", WrapText: true},
+	})
+}
+
+// styleSheet styles a processed worksheet and links the URL cell of each blob row to
+// the blob acted on
+func styleSheet(f *excelize.File, plan *sheetPlan) error {
+	links, err := newHyperlinker(f, plan.Name)
+	if err != nil {
+		return err
+	}
+	for _, task := range plan.Tasks {
+		if err := links.link(task.RowIndex, plan.URLColIndex, task.Source.URL(), "External"); err != nil {
+			return err
+		}
+	}
+	return styleResultSheet(f, plan.Name, plan.HeaderRow, plan.ResultColIndex, plan.Rows)
+}
+
+// addResultFormats colours the Result cells from the zero-based firstRow to rows by
+// code. Each style is a formula rule on the first cell of the range, which Excel shifts
+// down the column.
+func addResultFormats(f *excelize.File, sheetName string, firstRow, col, rows int) error {
+	first, err := excelize.CoordinatesToCellName(col+1, firstRow+1)
+	if err != nil {
+		return err
+	}
+	last, err := excelize.CoordinatesToCellName(col+1, rows)
+	if err != nil {
+		return err
+	}
+
+	var formats []excelize.ConditionalFormatOptions
+	for _, s := range resultStyles {
+		format, err := f.NewConditionalStyle(&excelize.Style{
+			Font: &excelize.Font{Color: s.Font},
+			Fill: excelize.Fill{Type: "pattern", Color: []string{s.Fill}, Pattern: 1},
+		})
+		if err != nil {
+			return err
+		}
+		matches := make([]string, len(s.Codes))
+		for i, code := range s.Codes {
+			matches[i] = fmt.Sprintf("%s=%q", first, code)
+		}
+		formats = append(formats, excelize.ConditionalFormatOptions{
+			Type:     "formula",
+			Criteria: "OR(" + strings.Join(matches, ",") + ")",
+			Format:   &format,
+		})
+	}
+	return f.SetConditionalFormat(sheetName, first+":"+last, formats)
+}
+
+// hasAutoFilter reports whether a worksheet already filters its rows, either with an
+// autofilter or through an Excel table
+func hasAutoFilter(f *excelize.File, sheetName string) bool {
+	if tables, err := f.GetTables(sheetName); err == nil && len(tables) > 0 {
+		return true
+	}
+	for _, name := range f.GetDefinedName() {
+		if name.Name == "_xlnm._FilterDatabase" && name.Scope == sheetName {
+			return true
+		}
+	}
+	return false
+}
+
+// hyperlinker turns cells of one worksheet into links to blob URLs, up to the number
+// of links Excel opens
+type hyperlinker struct {
+	f     *excelize.File
+	sheet string
+	style int
+	count int
+}
+
+// newHyperlinker returns a hyperlinker for sheetName
+func newHyperlinker(f *excelize.File, sheetName string) (*hyperlinker, error) {
+	style, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Color: "0563C1", Underline: "single"}})
+	if err != nil {
+		return nil, err
+	}
+	return &hyperlinker{f: f, sheet: sheetName, style: style}, nil
+}
+
+// link makes the cell at the zero-based row and col a link to target, of linkType
+// "External" or "Location". Cells that already link somewhere are left alone, and only
+// cells without formatting of their own take the hyperlink style.
+func (h *hyperlinker) link(row, col int, target, linkType string) error {
+	if h.count >= maxSheetHyperlinks {
+		return nil
+	}
+	cell, err := excelize.CoordinatesToCellName(col+1, row+1)
+	if err != nil {
+		return err
+	}
+	if ok, _, err := h.f.GetCellHyperLink(h.sheet, cell); err != nil || ok {
+		return err
+	}
+
+	if err := h.f.SetCellHyperLink(h.sheet, cell, target, linkType); err != nil {
+		return err
+	}
+	h.count++
+	if style, err := h.f.GetCellStyle(h.sheet, cell); err != nil || style != 0 {
+		return err
+	}
+	return h.f.SetCellStyle(h.sheet, cell, cell, h.style)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// summarySheetName is the name of the worksheet summarising a processed workbook
+const summarySheetName = "Summary"
+
+// rowSummary is the outcome of one blob, as listed on the Summary sheet
+type rowSummary struct {
+	// Sheet, Row and ResultCol locate the blob's result columns; Row and ResultCol are
+	// zero-based
+	Sheet     string
+	Row       int
+	ResultCol int
+	Location  blobLocation
+	// Value is the manifest cell of a row without a location, which could not be parsed
+	Value string
+	// Outcome is the stats key the blob was counted under
+	Outcome string
+	Code    string
+	Error   string
+}
+
+// newRowSummary returns the summary of a blob whose result r was written to the
+// zero-based row of sheet, starting at the zero-based resultCol
+func newRowSummary(sheet string, row, resultCol int, loc blobLocation, r rowResult, outcome string) rowSummary {
+	return rowSummary{Sheet: sheet, Row: row, ResultCol: resultCol, Location: loc, Outcome: outcome, Code: r.Code, Error: r.Error}
+}
+
+// summaryWriter appends rows to the Summary sheet
+type summaryWriter struct {
+	f     *excelize.File
+	sheet string
+	// row is the one-based row last written
+	row   int
+	links *hyperlinker
+}
+
+// add writes values to the next row and returns its cell in the first column
+func (w *summaryWriter) add(values ...interface{}) (string, error) {
+	w.row++
+	cell, err := excelize.CoordinatesToCellName(1, w.row)
+	if err != nil {
+		return "", err
+	}
+	return cell, w.f.SetSheetRow(w.sheet, cell, &values)
+}
+
+// blank leaves an empty row between sections
+func (w *summaryWriter) blank() {
+	w.row++
+}
+
+// locationCounts are the outcome counts of the blobs in one storage account container
+type locationCounts struct {
+	Account   string
+	Container string
+	Counts    map[string]int
+}
+
+// addSummarySheet adds a Summary sheet to a processed workbook and makes it the
+// active sheet. It lists the input file and timing, the stats and estimated cost, the
+// outcomes per storage account and container, and the rows that failed, each linked
+// to its Result cell. The counts are those at submission.
+func addSummarySheet(f *excelize.File, report *processReport, inputFile string) error {
+	name := uniqueSheetName(f, summarySheetName)
+	index, err := f.NewSheet(name)
+	if err != nil {
+		return err
+	}
+	links, err := newHyperlinker(f, name)
+	if err != nil {
+		return err
+	}
+	w := &summaryWriter{f: f, sheet: name, links: links}
+
+	mode := "Live"
+	changedKey, changedLabel := "changed", "Changed"
+	if report.DryRun {
+		mode = "Dry run, no tiers were changed"
+		changedKey, changedLabel = "wouldChange", "Would change"
+	}
+	overview := [][]interface{}{
+		{"Input file", inputFile},
+		{"Output file", report.OutputFile},
+		{"Mode", mode},
+		{"Started", report.StartedAt.Format(time.RFC3339)},
+		{"Finished", report.FinishedAt.Format(time.RFC3339)},
+		{"Duration", report.FinishedAt.Sub(report.StartedAt).Round(time.Second).String()},
+	}
+	for _, values := range overview {
+		if _, err := w.add(values...); err != nil {
+			return err
+		}
+	}
+
+	w.blank()
+	// The Summary is written once; the rehydration checker only rewrites the result
+	// columns of pending rows, so the counts are labelled as of submission
+	if _, err := w.add("Counts at submission", "Pending rows are updated in their result columns as they complete"); err != nil {
+		return err
+	}
+	for _, key := range statsKeys(report.Stats) {
+		if _, err := w.add(key, report.Stats[key]); err != nil {
+			return err
+		}
+	}
+
+	w.blank()
+	if _, err := w.add(fmt.Sprintf("Estimated cost (%s)", report.Currency), "Size (GB)", "Read", "Rehydration", "Early Deletion", "Total"); err != nil {
+		return err
+	}
+	costs := append(slices.Clone(report.Sheets), sheetReport{Name: "Total", Cost: report.Cost})
+	for _, sheet := range costs {
+		c := sheet.Cost
+		if _, err := w.add(sheet.Name, roundCost(c.SizeGB), roundCost(c.Read), roundCost(c.Rehydration), roundCost(c.EarlyDeletion), roundCost(c.Total)); err != nil {
+			return err
+		}
+	}
+
+	w.blank()
+	if _, err := w.add("Storage account", "Container", "Blobs", changedLabel, "Pending", "Skipped", "Errors"); err != nil {
+		return err
+	}
+	for _, l := range countByLocation(report.Sheets) {
+		total := 0
+		for _, n := range l.Counts {
+			total += n
+		}
+		if _, err := w.add(l.Account, l.Container, total, l.Counts[changedKey], l.Counts["pending"], l.Counts["skipped"], l.Counts["errors"]); err != nil {
+			return err
+		}
+	}
+
+	w.blank()
+	if _, err := w.add("Failed rows"); err != nil {
+		return err
+	}
+	if _, err := w.add("Sheet", "Row", "Blob", "Result", "Error"); err != nil {
+		return err
+	}
+	failed := 0
+	for _, sheet := range report.Sheets {
+		for _, r := range sheet.Rows {
+			if r.Outcome != "errors" {
+				continue
+			}
+			failed++
+			if err := w.addFailedRow(r); err != nil {
+				return err
+			}
+		}
+	}
+	if failed == 0 {
+		if _, err := w.add("None"); err != nil {
+			return err
+		}
+	}
+
+	if err := f.SetColWidth(name, "A", "C", 30); err != nil {
+		return err
+	}
+	f.SetActiveSheet(index)
+	return nil
+}
+
+// addFailedRow lists a failed row, linking its row number to the row's Result cell
+func (w *summaryWriter) addFailedRow(r rowSummary) error {
+	blob := r.Value
+	if blob == "" {
+		blob = r.Location.String()
+	}
+	if _, err := w.add(r.Sheet, r.Row+1, blob, r.Code, r.Error); err != nil {
+		return err
+	}
+
+	cell, err := excelize.CoordinatesToCellName(r.ResultCol+1, r.Row+1)
+	if err != nil {
+		return err
+	}
+	link := fmt.Sprintf("'%s'!%s", strings.ReplaceAll(r.Sheet, "'", "''"), cell)
+	return w.links.link(w.row-1, 1, link, "Location")
+}
+
+// countByLocation totals the outcomes of the summarised rows per storage account and
+// container, in name order
+func countByLocation(sheets []sheetReport) []*locationCounts {
+	byKey := make(map[string]*locationCounts)
+	for _, sheet := range sheets {
+		for _, r := range sheet.Rows {
+			if r.Value != "" {
+				continue // Rows without a location are only listed as failed rows
+			}
+			key := r.Location.Account + "/" + r.Location.Container
+			l, ok := byKey[key]
+			if !ok {
+				l = &locationCounts{Account: r.Location.Account, Container: r.Location.Container, Counts: make(map[string]int)}
+				byKey[key] = l
+			}
+			l.Counts[r.Outcome]++
+		}
+	}
+
+	locations := make([]*locationCounts, 0, len(byKey))
+	for _, l := range byKey {
+		locations = append(locations, l)
+	}
+	slices.SortFunc(locations, func(a, b *locationCounts) int {
+		if c := strings.Compare(a.Account, b.Account); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Container, b.Container)
+	})
+	return locations
+}
@@ -7,12 +7,17 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
 )
 
@@ -21,6 +26,21 @@ type ProcessedFile struct {
 	FileName    string    `json:"fileName"`
 	URL         string    `json:"url"`
 	ProcessedAt time.Time `json:"processedAt"`
+	DryRun      bool      `json:"dryRun"`
+}
+
+// dryRunMetadataKey is the blob metadata key autotier reads to run an upload as a dry run
+const dryRunMetadataKey = "dryrun"
+
+// uploaderMetadataKey is the blob metadata key autotier reads to pick the uploader's
+// column mapping. The value is path escaped, as metadata values must be ASCII.
+const uploaderMetadataKey = "uploader"
+
+// manifestContentTypes maps the manifest file extensions autotier accepts to their MIME types
+var manifestContentTypes = map[string]string{
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".csv":  "text/csv",
+	".json": "application/json",
 }
 
 // EventGridSubscriptionValidation represents the validation handshake request
@@ -95,13 +115,14 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	// Check file extension - only allow .xlsx files
+	// Check file extension - only allow manifest types autotier can read
 	fileName := header.Filename
 	fileExt := strings.ToLower(filepath.Ext(fileName))
 
-	if fileExt != ".xlsx" {
+	contentType, ok := manifestContentTypes[fileExt]
+	if !ok {
 		log.Printf("Invalid file type attempted: %s", fileName)
-		http.Error(w, "❌ Only .xlsx files are allowed. Please upload an Excel file with .xlsx extension.", http.StatusBadRequest)
+		http.Error(w, "❌ Only .xlsx, .csv and .json files are allowed. Please upload an Excel workbook, a CSV file or a JSON manifest.", http.StatusBadRequest)
 		return
 	}
 
@@ -132,20 +153,42 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 	blobName := filepath.Base(fileName)
 	blobClient := containerClient.NewBlockBlobClient(blobName)
 
+	// A dry run asks autotier for a plan workbook instead of changing tiers
+	dryRun, _ := strconv.ParseBool(r.FormValue("dryRun"))
+	uploadOptions := &blockblob.UploadOptions{
+		HTTPHeaders: &blob.HTTPHeaders{BlobContentType: to.Ptr(contentType)},
+	}
+	uploadOptions.Metadata = make(map[string]*string)
+	if dryRun {
+		uploadOptions.Metadata[dryRunMetadataKey] = to.Ptr("true")
+	}
+	// App Service authentication names the signed-in user, whose column mapping
+	// overrides autotier applies. The header is only trustworthy behind that
+	// authentication, which strips it from client requests; without it any client can
+	// claim another uploader's overrides. Names are escaped to keep metadata ASCII.
+	if uploader := r.Header.Get("X-MS-CLIENT-PRINCIPAL-NAME"); uploader != "" {
+		uploadOptions.Metadata[uploaderMetadataKey] = to.Ptr(url.PathEscape(uploader))
+	}
+
 	ctx := context.Background()
-	_, err = blobClient.Upload(ctx, file, nil)
+	_, err = blobClient.Upload(ctx, file, uploadOptions)
 	if err != nil {
 		log.Printf("Upload failed: %+v", err)
 		http.Error(w, "failed to upload blob: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("Upload successful: %s", blobName)
+	log.Printf("Upload successful: %s (dry run: %t)", blobName, dryRun)
+
+	message := fmt.Sprintf("✅ Upload successful: %s. File is being processed...", blobName)
+	if dryRun {
+		message = fmt.Sprintf("✅ Upload successful: %s. A dry-run plan is being prepared...", blobName)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"status":       "success",
-		"message":      fmt.Sprintf("✅ Upload successful: %s. File is being processed...", blobName),
+		"message":      message,
 		"originalFile": blobName,
 	})
 }
@@ -236,7 +279,11 @@ func handleDownload(w http.ResponseWriter, r *http.Request) {
 	defer downloadResponse.Body.Close()
 
 	// Set response headers for file download
-	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	contentType, ok := manifestContentTypes[strings.ToLower(filepath.Ext(fileName))]
+	if !ok {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fileName))
 
 	// Stream the blob content to the response
@@ -308,19 +355,24 @@ func handleProcessedNotification(w http.ResponseWriter, r *http.Request) {
 
 		blobURL := event.Data.URL
 
-		// Only process files that end with _processed.xlsx
-		if strings.Contains(blobURL, "_processed.xlsx") {
-			log.Printf("📢 Event Grid: New processed file detected: %s", blobURL)
+		// Extract filename from URL
+		urlParts := strings.Split(blobURL, "/")
+		fileName := urlParts[len(urlParts)-1]
 
-			// Extract filename from URL
-			urlParts := strings.Split(blobURL, "/")
-			fileName := urlParts[len(urlParts)-1]
+		// Only process manifests that end with _processed, or _plan for dry runs
+		ext := filepath.Ext(fileName)
+		base := strings.TrimSuffix(fileName, ext)
+		_, supported := manifestContentTypes[strings.ToLower(ext)]
+		dryRun := strings.HasSuffix(base, "_plan")
+		if supported && (strings.HasSuffix(base, "_processed") || dryRun) {
+			log.Printf("📢 Event Grid: New processed file detected: %s", blobURL)
 
 			// Update latest processed file
 			latestProcessedFile = &ProcessedFile{
 				FileName:    fileName,
 				URL:         blobURL,
 				ProcessedAt: time.Now(),
+				DryRun:      dryRun,
 			}
 
 			log.Printf("✅ Updated latest processed file to: %s", fileName)